@@ -16,6 +16,11 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+		keygen()
+		return
+	}
+
 	log.Println("[Main] Starting up Discord Plays website")
 
 	err := godotenv.Load()
@@ -38,6 +43,16 @@ func main() {
 	wg.Add(1)
 	dpHttp := server.New(db)
 	check(db.AutoMigrate(&structure.ProjectItem{}))
+	check(db.AutoMigrate(&structure.BotIdea{}, &structure.BotIdeaVote{}))
+	check(db.AutoMigrate(&structure.BugReport{}))
+	check(db.AutoMigrate(&structure.NewsPost{}))
+	check(db.AutoMigrate(&structure.TeamMember{}, &structure.TeamMemberLink{}, &structure.SiteText{}))
+	check(db.AutoMigrate(&structure.SigningKey{}, &structure.ServerSecret{}))
+	check(db.AutoMigrate(&structure.OAuthClient{}, &structure.OAuthConsent{}, &structure.OAuthAuthCode{}))
+	check(db.AutoMigrate(&structure.LoginSession{}))
+	check(dropLegacyIds(db))
+	check(db.AutoMigrate(&structure.User{}, &structure.LinkedIdentity{}))
+	check(db.AutoMigrate(&structure.ApiKey{}))
 
 	//=====================
 	// Safe shutdown
@@ -62,6 +77,30 @@ func main() {
 	log.Printf("[Main] Goodbye\n")
 }
 
+// keygen prints SESSION_KEYS with a new key pair in front, the old pairs stay
+// so current logins keep working until they expire
+func keygen() {
+	_ = godotenv.Load()
+	pair, err := server.GenerateSessionKeyPair()
+	check(err)
+	keys := pair
+	if old := os.Getenv("SESSION_KEYS"); old != "" {
+		keys += "," + old
+	}
+	fmt.Printf("SESSION_KEYS=%s\n", keys)
+}
+
+// dropLegacyIds removes the unkeyed public IDs which used to be stored for
+// every user, SQLite rebuilds the table so AutoMigrate has to run after this to
+// put the indexes back
+func dropLegacyIds(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&structure.User{}, "legacy_id") {
+		return nil
+	}
+	log.Println("[Main] Dropping stored legacy public IDs")
+	return db.Migrator().DropColumn(&structure.User{}, "legacy_id")
+}
+
 func check(err error) {
 	if err != nil {
 		log.Fatal(err)
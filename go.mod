@@ -9,6 +9,8 @@ require (
 	github.com/gorilla/sessions v1.4.0
 	github.com/joho/godotenv v1.5.1
 	github.com/ravener/discord-oauth2 v0.0.0-20230514095040-ae65713199b3
+	github.com/yuin/goldmark v1.8.6
+	golang.org/x/image v0.34.0
 	golang.org/x/oauth2 v0.34.0
 	gorm.io/driver/sqlite v1.6.0
 	gorm.io/gorm v1.31.1
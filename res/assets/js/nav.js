@@ -0,0 +1,163 @@
+window.apiFrame = null;
+
+function loginWithDiscordStage2() {
+    popupCenterScreen(document.getElementById("loginRememberMe").checked ? '/login?remember=1' : '/login', 'Login with Discord', 600, 900, false);
+}
+
+function loginWithLinkedAccount(provider) {
+    let remember = document.getElementById("loginRememberMe").checked ? '&remember=1' : '';
+    popupCenterScreen('/login?provider=' + encodeURIComponent(provider) + remember, 'Login', 600, 900, false);
+}
+
+function checkCommunityMembership() {
+    popupCenterScreen('/login?community=1', 'Login with Discord', 600, 900, false);
+}
+
+function logoutOfDiscord() {
+    document.getElementById("logoutReturn").value = location.href;
+}
+
+window.addEventListener("load", function () {
+    check_user();
+    fetch_me().then(function () {
+        if (window.aa_discordplays_user === null && !is_same_site()) {
+            // The cookie might have been left off the fetch on a custom domain
+            check_id_domain();
+            return;
+        }
+        // Without the check frame this frame hears logouts from other tabs
+        listen_id_domain();
+    }, function () {
+        // Fall back to the check frame if the ID domain can't be reached directly
+        check_id_domain();
+    });
+});
+
+document.addEventListener("visibilitychange", function () {
+    // Pick up logins and logouts from other tabs
+    if (document.visibilityState === "visible" && window.apiFrame === null) {
+        fetch_me().catch(function () {
+        });
+    }
+});
+
+function fetch_me() {
+    return fetch(window.aa_discordplays_id_domain + "/api/me", {credentials: "include", cache: "no-store"}).then(function (res) {
+        if (!res.ok) throw new Error(res.statusText);
+        return res.json();
+    }).then(function (data) {
+        window.aa_discordplays_user = isObject(data.user) ? data.user : null;
+        window.aa_discordplays_token = typeof data.token === "string" ? data.token : null;
+        check_user();
+    });
+}
+
+// is_same_site is true on the Discord Plays subdomains, custom project domains
+// are another site to the ID domain
+function is_same_site() {
+    let site = new URL(window.aa_discordplays_id_domain).hostname.split(".").slice(-2).join(".");
+    return location.hostname === site || location.hostname.endsWith("." + site);
+}
+
+function check_id_domain() {
+    var f = document.createElement("iframe");
+    f.src = window.aa_discordplays_id_domain + "/check?parent=" + location.host
+    f.style.display = "none";
+    document.body.appendChild(f);
+    window.apiFrame = f;
+}
+
+function listen_id_domain() {
+    var f = document.createElement("iframe");
+    f.src = window.aa_discordplays_id_domain + "/listen?parent=" + location.host;
+    f.style.display = "none";
+    document.body.appendChild(f);
+}
+
+function check_user() {
+    let is_logged_in = window.aa_discordplays_user !== null;
+    showOrHideWithBool("loginBtn", !is_logged_in);
+    showOrHideWithBool("loginMenu", is_logged_in);
+    showOrHideWithBool("loginMenuMember", is_logged_in && window.aa_discordplays_user.member === true);
+    if (document.getElementById("loginMenuCommunity") !== null) {
+        showOrHideWithBool("loginMenuCommunity", is_logged_in && window.aa_discordplays_user.member !== true);
+    }
+
+    if (window.aa_discordplays_user !== null) {
+        document.getElementById("loginMenuName").textContent = window.aa_discordplays_user.display_name || window.aa_discordplays_user.username;
+        document.getElementById("loginMenuAvatar").src = window.aa_discordplays_user.avatar;
+    } else {
+        document.getElementById("loginMenuName").textContent = "Wumpus";
+        document.getElementById("loginMenuAvatar").src = "";
+    }
+}
+
+function showOrHideWithBool(id, v) {
+    var el = document.getElementById(id);
+    console.log(id);
+    el.style.display = v ? "inline-block" : "none";
+}
+
+window.onmessage = function (event) {
+    if (event.origin !== window.aa_discordplays_id_domain) return;
+    if (isObject(event.data)) {
+        console.log(event.data);
+        if (event.data.logout === "bye") {
+            // Logged out in another tab or on another subdomain
+            window.aa_discordplays_user = null;
+            window.aa_discordplays_token = null;
+            check_user();
+            return;
+        }
+        if (typeof event.data.token === "string") {
+            window.aa_discordplays_token = event.data.token;
+        }
+        if (isObject(event.data.user)) {
+            let d = Object.assign({username: null, id: null, avatar: null, admin: false}, event.data.user);
+            if (d.username === null || d.id === null || d.avatar === null) {
+                alert("Failed to log user in: the login data is structured correctly but probably corrupted");
+                return;
+            }
+            window.aa_discordplays_user = d;
+            check_user();
+            return;
+        }
+    }
+    alert("Failed to log user in: the login data was probably corrupted");
+}
+
+// So much easier to ask stackoverflow instead of using my brain
+// https://stackoverflow.com/a/51458052/10719432
+function isObject(obj) {
+    return obj != null && obj.constructor.name === "Object"
+}
+
+// OMG gotta love stackoverflow
+// https://stackoverflow.com/a/65739440/10719432
+function popupCenterScreen(url, title, w, h, focus) {
+    const top = (screen.availHeight - h) / 4, left = (screen.availWidth - w) / 2;
+    const popup = openWindow(url, title, `scrollbars=yes,width=${w},height=${h},top=${top},left=${left}`);
+    if (focus === true && window.focus) popup.focus();
+    return popup;
+}
+
+function openWindow(url, winnm, options) {
+    var wTop = firstAvailableValue([window.screen.availTop, window.screenY, window.screenTop, 0]);
+    var wLeft = firstAvailableValue([window.screen.availLeft, window.screenX, window.screenLeft, 0]);
+    var top = 0, left = 0;
+    var result;
+    if ((result = /top=(\d+)/g.exec(options))) top = parseInt(result[1]);
+    if ((result = /left=(\d+)/g.exec(options))) left = parseInt(result[1]);
+    if (options) {
+        options = options.replace("top=" + top, "top=" + (parseInt(top) + wTop));
+        options = options.replace("left=" + left, "left=" + (parseInt(left) + wLeft));
+        w = window.open(url, winnm, options);
+    } else w = window.open(url, winnm);
+    return w;
+}
+
+function firstAvailableValue(arr) {
+    for (var i = 0; i < arr.length; i++)
+        if (typeof arr[i] != 'undefined')
+            return arr[i];
+}
@@ -3,18 +3,16 @@
 package res
 
 import (
-	"fmt"
 	"io/fs"
 	"os"
 	"path"
 )
 
-func GetTemplateFileByName(a string) string {
-	b, err := os.ReadFile(path.Join("res/pages", a))
-	if err != nil {
-		return fmt.Sprintf("Error loading template file: '%s'", err.Error())
-	}
-	return string(b)
+// ReloadPages makes the server parse the page templates again when they change
+const ReloadPages = true
+
+func GetPagesFilesystem() fs.FS {
+	return os.DirFS(path.Join("res/pages"))
 }
 
 func GetAssetsFilesystem() fs.FS {
@@ -4,11 +4,12 @@ package res
 
 import (
 	"embed"
-	"fmt"
 	"io/fs"
-	"path"
 )
 
+// ReloadPages makes the server parse the page templates again when they change
+const ReloadPages = false
+
 var (
 	//go:embed pages
 	viewsFiles embed.FS
@@ -16,12 +17,12 @@ var (
 	assetsFiles embed.FS
 )
 
-func GetTemplateFileByName(a string) string {
-	b, err := viewsFiles.ReadFile(path.Join("pages", a))
+func GetPagesFilesystem() fs.FS {
+	f, err := fs.Sub(viewsFiles, "pages")
 	if err != nil {
-		return fmt.Sprintf("Error loading template file: '%s'", err.Error())
+		return nil
 	}
-	return string(b)
+	return f
 }
 
 func GetAssetsFilesystem() fs.FS {
@@ -1,14 +1,25 @@
 package server
 
 import (
-	"github.com/discord-plays/website/res"
+	"github.com/discord-plays/website/structure"
 	"github.com/gorilla/mux"
 	"net/http"
 )
 
 func SetupDiscordPlaysAdmin(dpHttp *DiscordPlaysHttp, router *mux.Router) {
-	router.HandleFunc("/", func(rw http.ResponseWriter, req *http.Request) {
-		_, dpUser, _ := dpHttp.dpSess.CheckLogin(req)
-		dpHttp.generatePage(rw, dpUser, "Discord Plays Admin", res.GetTemplateFileByName("admin.go.html"), nil)
-	})
+	router.HandleFunc("/", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		dpHttp.generatePage(rw, req, dpUser, dpHttp.newPageMeta(req, "Discord Plays Admin", ""), "admin.go.html", nil)
+	}))
+}
+
+// requireAdmin only calls next if the request comes from a logged in admin user
+func requireAdmin(dpHttp *DiscordPlaysHttp, next func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody)) func(rw http.ResponseWriter, req *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		_, dpUser, ok := dpHttp.dpSess.CheckLogin(req)
+		if !ok || !dpHttp.isAdminUser(dpUser.Id) {
+			dpHttp.writeError(rw, req, http.StatusForbidden)
+			return
+		}
+		next(rw, req, dpUser)
+	}
 }
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/discord-plays/website/structure"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+func SetupDiscordPlaysApi(dpHttp *DiscordPlaysHttp, idRouter *mux.Router) {
+	// /api/me tells pages on the other Discord Plays domains who is logged in.
+	// Subdomains are the same site so the session cookie is sent along, custom
+	// project domains are another site where browsers blocking third party
+	// cookies leave it off, nav.js falls back to the /check frame for those.
+	dpHttp.apiRoute(dpHttp.rateLimit("check", idRouter.HandleFunc("/api/me", func(rw http.ResponseWriter, req *http.Request) {
+		dpHttp.allowCredentialedCors(rw, req)
+		if req.Method == http.MethodOptions {
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "no-store")
+
+		me := struct {
+			User  *structure.DiscordPlaysUserBody `json:"user"`
+			Token string                          `json:"token,omitempty"`
+		}{}
+		_, meBody, ok := dpHttp.dpSess.CheckLogin(req)
+		if ok {
+			token, _, err := dpHttp.issueIdentityToken(meBody)
+			if err != nil {
+				log.Printf("[Http::Api] Failed to issue identity token: %s\n", err)
+				writeJsonError(rw, req, http.StatusInternalServerError, "internal server error")
+				return
+			}
+			me.User = dpHttp.convertToDpBody(meBody)
+			me.Token = token
+		}
+		_ = json.NewEncoder(rw).Encode(me)
+	}).Methods(http.MethodGet, http.MethodOptions)))
+}
+
+// allowCredentialedCors lets pages on the allowed redirect hosts read the
+// response with the session cookie, other origins get no CORS headers
+func (dpHttp *DiscordPlaysHttp) allowCredentialedCors(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Add("Vary", "Origin")
+	origin := req.Header.Get("Origin")
+	u, err := url.Parse(origin)
+	if origin == "" || err != nil || u.Path != "" || !dpHttp.isAllowedRedirectUrl(u) {
+		return
+	}
+	rw.Header().Set("Access-Control-Allow-Origin", origin)
+	rw.Header().Set("Access-Control-Allow-Credentials", "true")
+	if req.Method == http.MethodOptions {
+		rw.Header().Set("Access-Control-Allow-Methods", "GET")
+		rw.Header().Set("Access-Control-Max-Age", "600")
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	nfHttp "code.mrmelon54.com/melon/neutered-filesystem/http"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// assetCacheControl is sent with fingerprinted assets, changing the file
+// changes its url so browsers never need to check it again
+const assetCacheControl = "public, max-age=31536000, immutable"
+
+// assetManifest maps asset names such as "css/site.css" to fingerprinted names
+// such as "css/site.0123456789ab.css" which contain a hash of the file. Text
+// assets are compressed once and kept in memory.
+type assetManifest struct {
+	fsys    fs.FS
+	reload  bool
+	rwSync  *sync.RWMutex
+	hashed  map[string]string
+	names   map[string]string
+	gzipped map[string][]byte
+	files   http.Handler
+}
+
+// newAssetManifest hashes every asset once at startup, debug builds hash a
+// file again each time its url is needed so edits show up without a restart
+func newAssetManifest(fsys fs.FS, reload bool) (*assetManifest, error) {
+	a := &assetManifest{
+		fsys:    fsys,
+		reload:  reload,
+		rwSync:  &sync.RWMutex{},
+		hashed:  make(map[string]string),
+		names:   make(map[string]string),
+		gzipped: make(map[string][]byte),
+		files:   http.FileServer(nfHttp.New(http.FS(fsys))),
+	}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return a.add(name)
+	})
+	return a, err
+}
+
+func (a *assetManifest) add(name string) error {
+	b, err := fs.ReadFile(a.fsys, name)
+	if err != nil {
+		return err
+	}
+	hashed := hashedAssetName(name, b)
+	var gz []byte
+	if isCompressibleAsset(name) {
+		gz, err = gzipBytes(b)
+		if err != nil {
+			return err
+		}
+	}
+	a.rwSync.Lock()
+	defer a.rwSync.Unlock()
+	a.hashed[name] = hashed
+	a.names[hashed] = name
+	if gz != nil && len(gz) < len(b) {
+		a.gzipped[name] = gz
+	} else {
+		delete(a.gzipped, name)
+	}
+	return nil
+}
+
+func hashedAssetName(name string, b []byte) string {
+	sum := sha256.Sum256(b)
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:6]) + ext
+}
+
+// Url is the fingerprinted path for an asset, unknown assets keep their plain
+// path so a missing file is a 404 instead of a broken page
+func (a *assetManifest) Url(name string) string {
+	if a.reload {
+		_ = a.add(name)
+	}
+	a.rwSync.RLock()
+	defer a.rwSync.RUnlock()
+	if hashed, ok := a.hashed[name]; ok {
+		return "/assets/" + hashed
+	}
+	return "/assets/" + name
+}
+
+// ServeHTTP serves fingerprinted assets with immutable cache headers, plain
+// asset paths are still served for images linked from outside the site
+func (a *assetManifest) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	a.rwSync.RLock()
+	name, fingerprinted := a.names[req.URL.Path]
+	if !fingerprinted {
+		name = req.URL.Path
+	}
+	gz, compressed := a.gzipped[name]
+	a.rwSync.RUnlock()
+
+	if fingerprinted {
+		rw.Header().Set("Cache-Control", assetCacheControl)
+	}
+	if compressed && acceptsGzip(req) {
+		rw.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+		rw.Header().Set("Content-Encoding", "gzip")
+		http.ServeContent(rw, req, name, time.Time{}, bytes.NewReader(gz))
+		return
+	}
+	if fingerprinted {
+		http.ServeFileFS(rw, req, a.fsys, name)
+		return
+	}
+	a.files.ServeHTTP(rw, req)
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/discord-plays/website/structure"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const apiKeyPrefix = "dpk_"
+
+type botApiUser struct {
+	Id             string                `json:"id"`
+	Username       string                `json:"username"`
+	DisplayName    string                `json:"display_name"`
+	Avatar         string                `json:"avatar"`
+	ProfileUrl     string                `json:"profile_url,omitempty"`
+	LinkedAccounts []botApiLinkedAccount `json:"linked_accounts,omitempty"`
+	Preferences    botApiUserPreferences `json:"preferences"`
+}
+
+type botApiLinkedAccount struct {
+	Provider   string `json:"provider"`
+	Username   string `json:"username"`
+	ProfileUrl string `json:"profile_url,omitempty"`
+}
+
+type botApiUserPreferences struct {
+	PublicProfile      bool `json:"public_profile"`
+	ShowLinkedAccounts bool `json:"show_linked_accounts"`
+}
+
+func SetupDiscordPlaysBotApi(dpHttp *DiscordPlaysHttp, idRouter *mux.Router, adminRouter *mux.Router) {
+	// Bots look up the Discord Plays profile of a Discord user, users who
+	// turned off bot lookups are not found
+	dpHttp.apiRoute(dpHttp.rateLimit("api", idRouter.HandleFunc("/api/bot/users/{discordId:[0-9]+}", requireApiKey(dpHttp, func(rw http.ResponseWriter, req *http.Request, key *structure.ApiKey) {
+		user, err := dpHttp.findUser(mux.Vars(req)["discordId"])
+		if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !user.BotLookup) {
+			writeJsonError(rw, req, http.StatusNotFound, "user not found")
+			return
+		}
+		if err != nil {
+			log.Printf("[Http::BotApi] Failed to load user: %s\n", err)
+			writeJsonError(rw, req, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		profile := dpHttp.convertToDpBody(user.MeBody())
+		body := botApiUser{
+			Id:          user.PublicId,
+			Username:    profile.Username,
+			DisplayName: profile.DisplayName,
+			Avatar:      profile.Avatar,
+			Preferences: botApiUserPreferences{
+				PublicProfile:      user.PublicProfile,
+				ShowLinkedAccounts: user.ShowLinkedAccounts,
+			},
+		}
+		if user.PublicProfile {
+			body.ProfileUrl = dpHttp.rootUrl() + "/users/" + user.PublicId
+		}
+		if user.ShowLinkedAccounts {
+			for _, i := range user.LinkedIdentities {
+				body.LinkedAccounts = append(body.LinkedAccounts, botApiLinkedAccount{
+					Provider:   i.Provider,
+					Username:   i.Username,
+					ProfileUrl: i.ProfileUrl,
+				})
+			}
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(rw).Encode(body)
+	})))).Methods(http.MethodGet)
+
+	setupApiKeyAdmin(dpHttp, adminRouter)
+}
+
+// apiKeyContextKey holds the API key once the rate limiter has looked it up
+type apiKeyContextKey struct{}
+
+// requireApiKey only calls next if the request has a working API key
+func requireApiKey(dpHttp *DiscordPlaysHttp, next func(rw http.ResponseWriter, req *http.Request, key *structure.ApiKey)) func(rw http.ResponseWriter, req *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		key, ok := req.Context().Value(apiKeyContextKey{}).(*structure.ApiKey)
+		if !ok {
+			key, ok = dpHttp.getApiKey(apiKeyFromRequest(req))
+		}
+		if !ok {
+			rw.Header().Set("WWW-Authenticate", "Bot")
+			writeJsonError(rw, req, http.StatusUnauthorized, "invalid api key")
+			return
+		}
+		// Only touch the database once a minute per key
+		if now := time.Now(); key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > time.Minute {
+			dpHttp.db.Model(key).Update("last_used_at", now)
+		}
+		next(rw, req, key)
+	}
+}
+
+func (dpHttp *DiscordPlaysHttp) getApiKey(a string) (*structure.ApiKey, bool) {
+	if !strings.HasPrefix(a, apiKeyPrefix) {
+		return nil, false
+	}
+	key := &structure.ApiKey{}
+	if dpHttp.db.Where("key_hash = ? AND disabled = ?", hashOAuthSecret(a), false).Limit(1).Find(key).RowsAffected == 0 {
+		return nil, false
+	}
+	return key, true
+}
+
+func setupApiKeyAdmin(dpHttp *DiscordPlaysHttp, router *mux.Router) {
+	router.HandleFunc("/api-keys", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		dpHttp.generateApiKeyList(rw, req, dpUser, "", "")
+	})).Methods(http.MethodGet)
+	router.HandleFunc("/api-keys", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		name := strings.TrimSpace(req.PostFormValue("name"))
+		if name == "" {
+			dpHttp.generateApiKeyList(rw, req, dpUser, "", "A name is required")
+			return
+		}
+		secret, err := randomOAuthSecret()
+		if err != nil {
+			log.Printf("[Http::BotApi] Failed to generate API key: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		secret = apiKeyPrefix + secret
+		if err = dpHttp.db.Create(&structure.ApiKey{Name: name, KeyHash: hashOAuthSecret(secret)}).Error; err != nil {
+			log.Printf("[Http::BotApi] Failed to save API key: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		// The key is only ever shown once
+		dpHttp.generateApiKeyList(rw, req, dpUser, secret, "")
+	})).Methods(http.MethodPost)
+	router.HandleFunc("/api-keys/{id:[0-9]+}", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		id, err := strconv.ParseUint(mux.Vars(req)["id"], 10, 64)
+		if err != nil {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		dpHttp.db.Model(&structure.ApiKey{}).Where("id = ?", id).Update("disabled", req.PostFormValue("disabled") == "1")
+		http.Redirect(rw, req, "/api-keys", http.StatusSeeOther)
+	})).Methods(http.MethodPost)
+	router.HandleFunc("/api-keys/{id:[0-9]+}/delete", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		id, err := strconv.ParseUint(mux.Vars(req)["id"], 10, 64)
+		if err != nil {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		dpHttp.db.Unscoped().Delete(&structure.ApiKey{}, id)
+		http.Redirect(rw, req, "/api-keys", http.StatusSeeOther)
+	})).Methods(http.MethodPost)
+}
+
+func (dpHttp *DiscordPlaysHttp) generateApiKeyList(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody, secret, errMsg string) {
+	var keys []*structure.ApiKey
+	if err := dpHttp.db.Order("name asc").Find(&keys).Error; err != nil {
+		log.Printf("[Http::BotApi] Failed to load API keys: %s\n", err)
+		dpHttp.writeError(rw, req, http.StatusInternalServerError)
+		return
+	}
+	dpHttp.generatePage(rw, req, dpUser, dpHttp.newPageMeta(req, "Discord Plays Admin - API Keys", ""), "admin-api-keys.go.html", struct {
+		Keys     []*structure.ApiKey
+		Secret   string
+		Error    string
+		Endpoint string
+	}{
+		Keys:     keys,
+		Secret:   secret,
+		Error:    errMsg,
+		Endpoint: dpHttp.idUrl() + "/api/bot/users/{discordId}",
+	})
+}
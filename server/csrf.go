@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/gorilla/mux"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+)
+
+const (
+	csrfFieldName  = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+	csrfSecretSize = 32
+)
+
+// csrfToken is the token for forms on this page, the session cookie is shared
+// by every Discord Plays domain so the token works on all of them
+func (dpHttp *DiscordPlaysHttp) csrfToken(rw http.ResponseWriter, req *http.Request) string {
+	sess, _, _ := dpHttp.dpSess.CheckLogin(req)
+	secret, ok := dpHttp.dpSess.CsrfSecret(sess)
+	if !ok {
+		_ = sess.Save(req, rw)
+	}
+	return maskCsrfToken(secret)
+}
+
+// csrfPlaceholder stands in for the token in the shared page templates and is
+// swapped for the session's token once a page has been rendered, it is random
+// so page content can't contain it
+var csrfPlaceholder = newCsrfPlaceholder()
+
+// csrfFuncs are the template helpers for forms, csrfField goes inside the form
+// and csrfQuery goes on the action of multipart forms as their body is only
+// read by the handler
+var csrfFuncs = template.FuncMap{
+	"csrfField": func() template.HTML {
+		return template.HTML(`<input type="hidden" name="` + csrfFieldName + `" value="` + csrfPlaceholder + `">`)
+	},
+	// template.URL stops the = being escaped in the form action
+	"csrfQuery": func() template.URL {
+		return template.URL(csrfFieldName + "=" + csrfPlaceholder)
+	},
+}
+
+func newCsrfPlaceholder() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return "csrf-" + hex.EncodeToString(b)
+}
+
+// fillCsrfTokens puts the token into a rendered page, pages without forms
+// don't need one
+func (dpHttp *DiscordPlaysHttp) fillCsrfTokens(rw http.ResponseWriter, req *http.Request, page []byte) []byte {
+	if !bytes.Contains(page, []byte(csrfPlaceholder)) {
+		return page
+	}
+	return bytes.ReplaceAll(page, []byte(csrfPlaceholder), []byte(dpHttp.csrfToken(rw, req)))
+}
+
+// exemptFromCsrf is for routes which are never called by a browser with the
+// session cookie, such as the OAuth token endpoint
+func (dpHttp *DiscordPlaysHttp) exemptFromCsrf(route *mux.Route) {
+	dpHttp.csrfExempt[route] = true
+}
+
+// csrfMiddleware rejects unsafe requests from other sites or without the
+// token from csrfField
+func (dpHttp *DiscordPlaysHttp) csrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(rw, req)
+			return
+		}
+		if route := mux.CurrentRoute(req); route != nil && dpHttp.csrfExempt[route] {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		if !dpHttp.isAllowedOrigin(req) {
+			log.Printf("[Http::Csrf] Rejected %s %s%s from origin %q\n", req.Method, req.Host, req.URL.Path, req.Header.Get("Origin"))
+			dpHttp.writeError(rw, req, http.StatusForbidden)
+			return
+		}
+
+		token := req.Header.Get(csrfHeaderName)
+		if token == "" {
+			if mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mt == "multipart/form-data" {
+				token = req.URL.Query().Get(csrfFieldName)
+			} else {
+				token = req.PostFormValue(csrfFieldName)
+			}
+		}
+		sess, _, _ := dpHttp.dpSess.CheckLogin(req)
+		if !dpHttp.dpSess.ValidCsrfToken(sess, unmaskCsrfToken(token)) {
+			log.Printf("[Http::Csrf] Rejected %s %s%s without a valid token\n", req.Method, req.Host, req.URL.Path)
+			dpHttp.writeError(rw, req, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// isAllowedOrigin checks the Origin header, or the Referer for browsers which
+// leave it out, came from one of the Discord Plays domains. Requests with
+// neither, or with a "null" origin, are left to the token check.
+func (dpHttp *DiscordPlaysHttp) isAllowedOrigin(req *http.Request) bool {
+	if requestOrigin(req) == "" {
+		return true
+	}
+	return dpHttp.isSameSiteRequest(req)
+}
+
+// maskCsrfToken hides the secret behind a new one-time pad each time so the
+// token can't be recovered from compressed pages
+func maskCsrfToken(secret []byte) string {
+	pad := make([]byte, len(secret))
+	_, _ = rand.Read(pad)
+	masked := make([]byte, len(secret)*2)
+	copy(masked, pad)
+	for i := range secret {
+		masked[len(secret)+i] = pad[i] ^ secret[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+func unmaskCsrfToken(token string) []byte {
+	masked, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(masked) != csrfSecretSize*2 {
+		return nil
+	}
+	secret := make([]byte, csrfSecretSize)
+	for i := range secret {
+		secret[i] = masked[i] ^ masked[csrfSecretSize+i]
+	}
+	return secret
+}
+
+func newCsrfSecret() []byte {
+	secret := make([]byte, csrfSecretSize)
+	_, _ = rand.Read(secret)
+	return secret
+}
+
+func csrfSecretsEqual(a, b []byte) bool {
+	return len(a) == csrfSecretSize && subtle.ConstantTimeCompare(a, b) == 1
+}
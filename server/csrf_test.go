@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"github.com/discord-plays/website/res"
+	"github.com/gorilla/mux"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// newSessionTestHttp is newRedirectTestHttp with sessions and the page
+// templates so requests can go through the middleware
+func newSessionTestHttp(t *testing.T) *DiscordPlaysHttp {
+	t.Helper()
+	key, err := GenerateSessionKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SESSION_KEYS", key)
+	t.Setenv("SESSION_ENCRYPTION", "")
+	t.Setenv("COOKIE_DOMAIN", "")
+
+	dpHttp := newRedirectTestHttp()
+	dpHttp.dpSess = NewDiscordPlaysSessions()
+	dpHttp.assets, err = newAssetManifest(res.GetAssetsFilesystem(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dpHttp.templates, err = newPageTemplates(res.GetPagesFilesystem(), false, dpHttp.assets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dpHttp
+}
+
+// newCsrfTestSession is the cookie for a new session and a form token for it
+func newCsrfTestSession(t *testing.T, dpHttp *DiscordPlaysHttp) (*http.Cookie, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	token := dpHttp.csrfToken(rec, httptest.NewRequest(http.MethodGet, "https://dp.test/", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("csrfToken set %d cookies, want 1", len(cookies))
+	}
+	return cookies[0], token
+}
+
+func TestCsrfMiddleware(t *testing.T) {
+	dpHttp := newSessionTestHttp(t)
+	cookie, token := newCsrfTestSession(t, dpHttp)
+	_, otherToken := newCsrfTestSession(t, dpHttp)
+
+	router := mux.NewRouter()
+	ok := func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	}
+	router.HandleFunc("/form", ok)
+	dpHttp.exemptFromCsrf(router.HandleFunc("/token", ok))
+	router.Use(dpHttp.csrfMiddleware)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		origin  string
+		referer string
+		form    string
+		header  string
+		want    int
+	}{
+		{"get without a token", http.MethodGet, "/form", "https://evil.test", "", "", "", http.StatusNoContent},
+		{"form with a token", http.MethodPost, "/form", "https://dp.test", "", token, "", http.StatusNoContent},
+		{"form from a project page", http.MethodPost, "/form", "https://minesweeper.dp.test", "", token, "", http.StatusNoContent},
+		{"form from a page without a referrer", http.MethodPost, "/form", "null", "", token, "", http.StatusNoContent},
+		{"form without origin or referer", http.MethodPost, "/form", "", "", token, "", http.StatusNoContent},
+		{"token in the header", http.MethodPost, "/form", "https://dp.test", "", "", token, http.StatusNoContent},
+		{"exempt route", http.MethodPost, "/token", "https://evil.test", "", "", "", http.StatusNoContent},
+		{"form without a token", http.MethodPost, "/form", "https://dp.test", "", "", "", http.StatusForbidden},
+		{"null origin without a token", http.MethodPost, "/form", "null", "", "", "", http.StatusForbidden},
+		{"token from another session", http.MethodPost, "/form", "https://dp.test", "", otherToken, "", http.StatusForbidden},
+		{"unmasked token", http.MethodPost, "/form", "https://dp.test", "", "not-a-token", "", http.StatusForbidden},
+		{"form from another site", http.MethodPost, "/form", "https://evil.test", "", token, "", http.StatusForbidden},
+		{"null origin from another site", http.MethodPost, "/form", "null", "https://evil.test/", token, "", http.StatusForbidden},
+		{"delete from another site", http.MethodDelete, "/form", "https://evil.test", "", "", token, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.form != "" {
+				form.Set(csrfFieldName, tt.form)
+			}
+			req := httptest.NewRequest(tt.method, "https://dp.test"+tt.path, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.AddCookie(cookie)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				req.Header.Set("Referer", tt.referer)
+			}
+			if tt.header != "" {
+				req.Header.Set(csrfHeaderName, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCsrfMiddlewareMultipart(t *testing.T) {
+	dpHttp := newSessionTestHttp(t)
+	cookie, token := newCsrfTestSession(t, dpHttp)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/upload", func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	router.Use(dpHttp.csrfMiddleware)
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"token in the query", csrfFieldName + "=" + token, http.StatusNoContent},
+		{"token only in the body", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := new(bytes.Buffer)
+			mw := multipart.NewWriter(body)
+			_ = mw.WriteField(csrfFieldName, token)
+			_ = mw.Close()
+			req := httptest.NewRequest(http.MethodPost, "https://dp.test/upload?"+tt.query, body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			req.Header.Set("Origin", "null")
+			req.AddCookie(cookie)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("POST /upload?%s = %d, want %d", tt.query, rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCsrfTokenMasking(t *testing.T) {
+	secret := newCsrfSecret()
+	a, b := maskCsrfToken(secret), maskCsrfToken(secret)
+	if a == b {
+		t.Errorf("maskCsrfToken gave the same token twice")
+	}
+	for _, token := range []string{a, b} {
+		if !csrfSecretsEqual(secret, unmaskCsrfToken(token)) {
+			t.Errorf("unmaskCsrfToken(%q) did not give the secret back", token)
+		}
+	}
+	if unmaskCsrfToken(a[:len(a)-2]) != nil {
+		t.Errorf("unmaskCsrfToken accepted a short token")
+	}
+}
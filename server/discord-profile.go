@@ -0,0 +1,40 @@
+package server
+
+import (
+	"fmt"
+	"github.com/discord-plays/website/structure"
+	"strconv"
+	"strings"
+)
+
+// discordAvatarUrl follows the Discord CDN rules, users without an avatar get
+// one of the default avatars picked from their ID or old discriminator
+func discordAvatarUrl(meBody *structure.DiscordMeBody) string {
+	if meBody.Avatar == "" {
+		var index uint64
+		if meBody.Discriminator == "" || meBody.Discriminator == "0" {
+			id, _ := strconv.ParseUint(meBody.Id, 10, 64)
+			index = (id >> 22) % 6
+		} else {
+			d, _ := strconv.ParseUint(meBody.Discriminator, 10, 64)
+			index = d % 5
+		}
+		return fmt.Sprintf("https://cdn.discordapp.com/embed/avatars/%d.png", index)
+	}
+	return fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.%s?size=256", meBody.Id, meBody.Avatar, discordImageExt(meBody.Avatar))
+}
+
+func discordBannerUrl(meBody *structure.DiscordMeBody) string {
+	if meBody.Banner == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://cdn.discordapp.com/banners/%s/%s.%s?size=600", meBody.Id, meBody.Banner, discordImageExt(meBody.Banner))
+}
+
+// discordImageExt picks gif for animated image hashes
+func discordImageExt(hash string) string {
+	if strings.HasPrefix(hash, "a_") {
+		return "gif"
+	}
+	return "png"
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const requestIdHeader = "X-Request-Id"
+
+type requestIdKey struct{}
+
+// errStopWalk ends a router walk early
+var errStopWalk = errors.New("stop walking the routes")
+
+// errorMessages are shown on the error pages, other statuses only get the
+// status text
+var errorMessages = map[int]string{
+	http.StatusBadRequest:          "Something about that request didn't look right. Go back and try again.",
+	http.StatusForbidden:           "You don't have permission to do that. Try logging in or going back to the page you came from.",
+	http.StatusNotFound:            "We couldn't find the page you were looking for.",
+	http.StatusMethodNotAllowed:    "That page can't be used like that.",
+	http.StatusTooManyRequests:     "You're doing that too often. Wait a moment and try again.",
+	http.StatusInternalServerError: "Something went wrong on our side. Please try again later.",
+}
+
+// requestIdHandler gives every request an ID which is sent back in
+// X-Request-Id and shown on error pages so reports can be matched with the log
+func requestIdHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		id := uuid.NewString()
+		rw.Header().Set(requestIdHeader, id)
+		next.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), requestIdKey{}, id)))
+	})
+}
+
+func requestId(req *http.Request) string {
+	id, _ := req.Context().Value(requestIdKey{}).(string)
+	return id
+}
+
+// apiRoute marks a route as called by scripts and bots rather than opened in a
+// browser, its errors are sent as JSON
+func (dpHttp *DiscordPlaysHttp) apiRoute(route *mux.Route) *mux.Route {
+	dpHttp.apiRoutes[route] = true
+	return route
+}
+
+func (dpHttp *DiscordPlaysHttp) isApiRequest(req *http.Request) bool {
+	if route := mux.CurrentRoute(req); route != nil {
+		return dpHttp.apiRoutes[route]
+	}
+	// 404s and 405s have no current route so look for the route the path
+	// belongs to
+	for route := range dpHttp.apiRoutes {
+		var match mux.RouteMatch
+		if route.Match(req, &match) || match.MatchErr == mux.ErrMethodMismatch {
+			return true
+		}
+	}
+	return strings.HasPrefix(req.URL.Path, "/api/")
+}
+
+// writeError sends the error page for the status, or a JSON error for API
+// routes. The cause belongs in the log and never on the page.
+func (dpHttp *DiscordPlaysHttp) writeError(rw http.ResponseWriter, req *http.Request, status int) {
+	if status >= http.StatusInternalServerError {
+		log.Printf("[Http::Error] %d for %s %s%s, request %s\n", status, req.Method, req.Host, req.URL.Path, requestId(req))
+	}
+	if dpHttp.isApiRequest(req) {
+		writeJsonError(rw, req, status, strings.ToLower(http.StatusText(status)))
+		return
+	}
+
+	_, dpUser, _ := dpHttp.dpSess.CheckLogin(req)
+	meta := dpHttp.newPageMeta(req, http.StatusText(status), "")
+	meta.NoIndex = true
+	message, ok := errorMessages[status]
+	if !ok {
+		message = http.StatusText(status)
+	}
+	dpHttp.generatePageWithStatus(rw, req, status, dpUser, meta, "error.go.html", struct {
+		Status    int
+		Title     string
+		Message   string
+		RequestId string
+	}{
+		Status:    status,
+		Title:     http.StatusText(status),
+		Message:   message,
+		RequestId: requestId(req),
+	})
+}
+
+func (dpHttp *DiscordPlaysHttp) notFound(rw http.ResponseWriter, req *http.Request) {
+	if dpHttp.isMethodMismatch(req) {
+		dpHttp.methodNotAllowed(rw, req)
+		return
+	}
+	dpHttp.writeError(rw, req, http.StatusNotFound)
+}
+
+// isMethodMismatch finds routes which match everything but the method, mux
+// loses these inside the host subrouters once a later route matches the host
+func (dpHttp *DiscordPlaysHttp) isMethodMismatch(req *http.Request) bool {
+	if dpHttp.router == nil {
+		return false
+	}
+	found := false
+	_ = dpHttp.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		// Host routes only hold subrouters, their routes are walked too
+		if route.GetHandler() == nil {
+			return nil
+		}
+		var match mux.RouteMatch
+		if !route.Match(req, &match) && match.MatchErr == mux.ErrMethodMismatch {
+			found = true
+			return errStopWalk
+		}
+		return nil
+	})
+	return found
+}
+
+func (dpHttp *DiscordPlaysHttp) methodNotAllowed(rw http.ResponseWriter, req *http.Request) {
+	dpHttp.writeError(rw, req, http.StatusMethodNotAllowed)
+}
+
+// writeJsonError is the error body for API routes
+func writeJsonError(rw http.ResponseWriter, req *http.Request, status int, msg string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(struct {
+		Error     string `json:"error"`
+		RequestId string `json:"request_id,omitempty"`
+	}{
+		Error:     msg,
+		RequestId: requestId(req),
+	})
+}
@@ -1,22 +1,20 @@
 package server
 
 import (
+	"bytes"
 	"context"
-	"crypto/md5"
 	_ "embed"
-	"encoding/hex"
 	"fmt"
 	"github.com/discord-plays/website/res"
 	"github.com/discord-plays/website/structure"
 	"github.com/discord-plays/website/utils"
 	"github.com/gorilla/mux"
-	"github.com/ravener/discord-oauth2"
-	"golang.org/x/oauth2"
 	"gorm.io/gorm"
 	"html/template"
-	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"sync"
@@ -24,36 +22,99 @@ import (
 )
 
 type DiscordPlaysHttp struct {
-	db            *gorm.DB
-	httpSrv       *http.Server
-	projectData   []*structure.ProjectItem
-	projectItems  map[string]*structure.ProjectItem
-	projectHeader []string
-	rwSync        *sync.RWMutex
-	Protocol      string
-	Domain        *structure.Domains
-	oAuthConf     *oauth2.Config
-	dpSess        *DiscordPlaysSessions
-	dpAdmins      []string
+	db              *gorm.DB
+	httpSrv         *http.Server
+	projectData     []*structure.ProjectItem
+	projectItems    map[string]*structure.ProjectItem
+	projectAliases  map[string]*structure.ProjectItem
+	projectDomains  map[string]*structure.ProjectItem
+	projectHeader   []string
+	rwSync          *sync.RWMutex
+	csrfExempt      map[*mux.Route]bool
+	apiRoutes       map[*mux.Route]bool
+	router          *mux.Router
+	rateLimits      *rateLimiter
+	rateLimitRoutes map[*mux.Route]*rateLimitGroup
+	trustedProxies  []*net.IPNet
+	renewLocks      *renewLocks
+	Protocol        string
+	Domain          *structure.Domains
+	identity        IdentityProvider
+	linkProviders   map[string]LinkedAccountProvider
+	dpSess          *DiscordPlaysSessions
+	dpAdmins        []string
+	communityGuild  string
+	shareCards      *shareCardCache
+	projectImages   *projectImageCache
+	tokens          *tokenSigner
+	publicIds       *publicIds
+	templates       *pageTemplates
+	assets          *assetManifest
+	stop            chan struct{}
 }
 
 func New(db *gorm.DB) *DiscordPlaysHttp {
 	return &DiscordPlaysHttp{
-		db:           db,
-		projectData:  make([]*structure.ProjectItem, 0),
-		projectItems: make(map[string]*structure.ProjectItem),
-		rwSync:       &sync.RWMutex{},
+		db:              db,
+		projectData:     make([]*structure.ProjectItem, 0),
+		projectItems:    make(map[string]*structure.ProjectItem),
+		projectAliases:  make(map[string]*structure.ProjectItem),
+		projectDomains:  make(map[string]*structure.ProjectItem),
+		rwSync:          &sync.RWMutex{},
+		renewLocks:      newRenewLocks(),
+		csrfExempt:      make(map[*mux.Route]bool),
+		apiRoutes:       make(map[*mux.Route]bool),
+		rateLimitRoutes: make(map[*mux.Route]*rateLimitGroup),
+		shareCards:      newShareCardCache(),
+		projectImages:   newProjectImageCache(),
+		stop:            make(chan struct{}),
 	}
 }
 
 func (dpHttp *DiscordPlaysHttp) Shutdown() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
+	close(dpHttp.stop)
 	return dpHttp.httpSrv.Shutdown(ctx)
 }
 
 func (dpHttp *DiscordPlaysHttp) StartupHttp(port int, wg *sync.WaitGroup) {
+	dpHttp.dpSess = NewDiscordPlaysSessions()
+
+	assets, err := newAssetManifest(res.GetAssetsFilesystem(), res.ReloadPages)
+	if err != nil {
+		log.Fatalf("[Http::Assets] Failed to build the asset manifest: %s\n", err)
+	}
+	dpHttp.assets = assets
+
+	templates, err := newPageTemplates(res.GetPagesFilesystem(), res.ReloadPages, assets)
+	if err != nil {
+		log.Fatalf("[Http::Templates] Failed to parse page templates: %s\n", err)
+	}
+	dpHttp.templates = templates
+
+	fonts, err := loadShareCardFonts()
+	if err != nil {
+		log.Fatalf("[Http::ShareCard] Failed to load fonts: %s\n", err)
+	}
+	dpHttp.shareCards.fonts = fonts
+
 	dpHttp.loadProjectsFromDB()
+	dpHttp.seedTeam()
+
+	tokens, err := newTokenSigner(dpHttp.db, dpHttp.dpSess.signingSealKeys)
+	if err != nil {
+		log.Fatalf("[Http::Tokens] Failed to load signing keys: %s\n", err)
+	}
+	dpHttp.tokens = tokens
+	go dpHttp.tokens.rotateLoop(dpHttp.stop)
+
+	ids, err := newPublicIds(dpHttp.db)
+	if err != nil {
+		log.Fatalf("[Http::PublicIds] Failed to load the public ID key: %s\n", err)
+	}
+	dpHttp.publicIds = ids
+	dpHttp.migratePublicIds()
 
 	wg.Add(1)
 	log.Printf("[Http::Bind] Starting HTTP server on %d\n", port)
@@ -68,6 +129,8 @@ func (dpHttp *DiscordPlaysHttp) loadProjectsFromDB() {
 	dpHttp.db.Model(&structure.ProjectItem{}).Find(&projects)
 
 	projectMap := make(map[string]*structure.ProjectItem)
+	aliasMap := make(map[string]*structure.ProjectItem)
+	domainMap := make(map[string]*structure.ProjectItem)
 	for _, p := range projects {
 		utils.EmptyStringIfNil(p.Code)
 		utils.EmptyStringIfNil(p.Name)
@@ -89,10 +152,18 @@ func (dpHttp *DiscordPlaysHttp) loadProjectsFromDB() {
 		*p.Github = strings.TrimSpace(*p.Github)
 
 		projectMap[*p.Code] = p
+		for _, a := range splitProjectHosts(p.Aliases) {
+			aliasMap[a] = p
+		}
+		for _, d := range splitProjectHosts(p.Domains) {
+			domainMap[d] = p
+		}
 	}
 
 	dpHttp.projectData = projects
 	dpHttp.projectItems = projectMap
+	dpHttp.projectAliases = aliasMap
+	dpHttp.projectDomains = domainMap
 }
 
 func (dpHttp *DiscordPlaysHttp) startHttpServer(port int, wg *sync.WaitGroup) {
@@ -110,38 +181,50 @@ func (dpHttp *DiscordPlaysHttp) startHttpServer(port int, wg *sync.WaitGroup) {
 	linkNotion := os.Getenv("LINK_NOTION")
 	linkGithub := os.Getenv("LINK_GITHUB")
 
-	discordClient := os.Getenv("DISCORD_CLIENT")
-	discordSecret := os.Getenv("DISCORD_SECRET")
-
 	dpHttp.dpAdmins = strings.Split(os.Getenv("DP_ADMINS"), ",")
+	dpHttp.communityGuild = os.Getenv("DISCORD_GUILD_ID")
 
-	dpHttp.oAuthConf = &oauth2.Config{
-		RedirectURL:  fmt.Sprintf("%s://%s/auth/callback", dpHttp.Protocol, dpHttp.Domain.IdDomain),
-		ClientID:     discordClient,
-		ClientSecret: discordSecret,
-		Scopes:       []string{discord.ScopeIdentify},
-		Endpoint:     discord.Endpoint,
-	}
-	dpHttp.dpSess = NewDiscordPlaysSessions()
+	dpHttp.identity = dpHttp.newIdentityProvider(os.Getenv("IDENTITY_PROVIDER"))
+	dpHttp.linkProviders = dpHttp.newLinkProviders()
+	dpHttp.rateLimits = newRateLimiter()
+	dpHttp.trustedProxies = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	go dpHttp.rateLimits.pruneLoop(dpHttp.stop)
 
 	router := mux.NewRouter()
-	SetupDiscordPlaysRoot(dpHttp, router.Host(dpHttp.Domain.RootDomain).Subrouter(), linkDiscord, linkNotion, linkGithub)
-	SetupDiscordPlaysId(dpHttp, router.Host(dpHttp.Domain.IdDomain).Subrouter())
-	SetupDiscordPlaysAdmin(dpHttp, router.Host(dpHttp.Domain.AdminDomain).Subrouter())
+	dpHttp.router = router
+	rootRouter := router.Host(dpHttp.Domain.RootDomain).Subrouter()
+	adminRouter := router.Host(dpHttp.Domain.AdminDomain).Subrouter()
+	SetupDiscordPlaysRoot(dpHttp, rootRouter, linkDiscord, linkNotion, linkGithub)
+	idRouter := router.Host(dpHttp.Domain.IdDomain).Subrouter()
+	SetupDiscordPlaysId(dpHttp, idRouter)
+	SetupDiscordPlaysMockProvider(dpHttp, idRouter)
+	SetupDiscordPlaysLinkedAccounts(dpHttp, idRouter)
+	SetupDiscordPlaysApi(dpHttp, idRouter)
+	SetupDiscordPlaysBotApi(dpHttp, idRouter, adminRouter)
+	SetupDiscordPlaysAdmin(dpHttp, adminRouter)
+	SetupDiscordPlaysIdeas(dpHttp, rootRouter, adminRouter)
+	SetupDiscordPlaysReports(dpHttp, rootRouter, adminRouter)
+	SetupDiscordPlaysNews(dpHttp, rootRouter, adminRouter)
+	SetupDiscordPlaysTeam(dpHttp, rootRouter, adminRouter)
+	SetupDiscordPlaysUsers(dpHttp, rootRouter)
+	SetupDiscordPlaysSeo(dpHttp, rootRouter, idRouter, adminRouter)
+	SetupDiscordPlaysShareCards(dpHttp, rootRouter)
+	SetupDiscordPlaysOAuth(dpHttp, idRouter, adminRouter)
+	SetupDiscordPlaysRateLimits(dpHttp, adminRouter)
 	SetupDiscordPlaysProjects(dpHttp, router)
 	router.HandleFunc("/login", func(rw http.ResponseWriter, req *http.Request) {
-		http.Redirect(rw, req, fmt.Sprintf("%s://%s/login?redirect=%s", dpHttp.Protocol, dpHttp.Domain.IdDomain, req.Host), http.StatusTemporaryRedirect)
-	})
-	router.HandleFunc("/logout", func(rw http.ResponseWriter, req *http.Request) {
-		sess, _, _ := dpHttp.dpSess.CheckLogin(req)
-		delete(sess.Values, "dpUser")
-		_ = sess.Save(req, rw)
+		q := req.URL.Query()
+		q.Set("redirect", req.Host)
+		http.Redirect(rw, req, fmt.Sprintf("%s://%s/login?%s", dpHttp.Protocol, dpHttp.Domain.IdDomain, q.Encode()), http.StatusTemporaryRedirect)
 	})
-	router.NotFoundHandler = http.NotFoundHandler()
+	router.HandleFunc("/logout", dpHttp.logout).Methods(http.MethodPost)
+	router.NotFoundHandler = http.HandlerFunc(dpHttp.notFound)
+	router.MethodNotAllowedHandler = http.HandlerFunc(dpHttp.methodNotAllowed)
+	router.Use(dpHttp.rateLimitMiddleware, dpHttp.renewLoginMiddleware, dpHttp.csrfMiddleware)
 
 	dpHttp.httpSrv = &http.Server{
 		Addr:    fmt.Sprintf(":%d", port),
-		Handler: router,
+		Handler: requestIdHandler(gzipHandler(router)),
 	}
 	err := dpHttp.httpSrv.ListenAndServe()
 	if err != nil {
@@ -153,16 +236,41 @@ func (dpHttp *DiscordPlaysHttp) startHttpServer(port int, wg *sync.WaitGroup) {
 	}
 }
 
+// logout is posted by the form in the nav on every domain, the id domain then
+// tells the other open tabs
+func (dpHttp *DiscordPlaysHttp) logout(rw http.ResponseWriter, req *http.Request) {
+	sess, _, _ := dpHttp.dpSess.CheckLogin(req)
+	dpHttp.endLogin(sess)
+	_ = sess.Save(req, rw)
+
+	returnUrl := req.PostFormValue("return")
+	if returnUrl == "" {
+		returnUrl = req.Referer()
+	}
+	http.Redirect(rw, req, dpHttp.idUrl()+"/logout/done?return="+url.QueryEscape(dpHttp.safeReturnUrl(returnUrl)), http.StatusSeeOther)
+}
+
+func (dpHttp *DiscordPlaysHttp) rootUrl() string {
+	return fmt.Sprintf("%s://%s", dpHttp.Protocol, dpHttp.Domain.RootDomain)
+}
+
+func (dpHttp *DiscordPlaysHttp) idUrl() string {
+	return fmt.Sprintf("%s://%s", dpHttp.Protocol, dpHttp.Domain.IdDomain)
+}
+
 func (dpHttp *DiscordPlaysHttp) convertToDpBody(meBody *structure.DiscordMeBody) *structure.DiscordPlaysUserBody {
 	if meBody == nil {
 		return nil
 	}
-	hash := md5.Sum([]byte(meBody.Id))
 	return &structure.DiscordPlaysUserBody{
-		Id:       hex.EncodeToString(hash[:]),
-		Username: fmt.Sprintf("%s#%s", meBody.Username, meBody.Discriminator),
-		Avatar:   fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png?size=256", meBody.Id, meBody.Avatar),
-		Admin:    dpHttp.isAdminUser(meBody.Id),
+		Id:          dpHttp.publicIds.Id(meBody.Id),
+		LegacyId:    dpHttp.publicIds.LegacyId(meBody.Id),
+		Username:    meBody.Tag(),
+		DisplayName: meBody.DisplayName(),
+		Avatar:      discordAvatarUrl(meBody),
+		Banner:      discordBannerUrl(meBody),
+		Admin:       dpHttp.isAdminUser(meBody.Id),
+		Member:      meBody.Member,
 	}
 }
 
@@ -175,63 +283,59 @@ func (dpHttp *DiscordPlaysHttp) isAdminUser(a string) bool {
 	return false
 }
 
-func (dpHttp *DiscordPlaysHttp) generatePage(rw http.ResponseWriter, dpUser *structure.DiscordMeBody, title, templatePage string, data interface{}) {
-	funcMap := template.FuncMap{
-		"mod": func(i, j int) int {
-			return i % j
-		},
-	}
-
-	dpHttp.rwSync.RLock()
-	defer dpHttp.rwSync.RUnlock()
-
-	dpMeUser := dpHttp.convertToDpBody(dpUser)
-
-	rw.Header().Add("Content-Type", "text/html")
-	_, _ = rw.Write([]byte("<!DOCTYPE html><html><head>"))
-	fillPage(rw, "head", res.GetTemplateFileByName("head.go.html"), struct{ Title string }{Title: title})
-	_, _ = rw.Write([]byte("</head><body class=\"bg-dark\">"))
-	fillPage(rw, "nav", res.GetTemplateFileByName("nav.go.html"), struct {
-		RootDomain       template.HTMLAttr
-		IdDomain         template.HTMLAttr
-		DiscordPlaysUser *structure.DiscordPlaysUserBody
-		Projects         []*structure.ProjectItem
-	}{
-		RootDomain:       template.HTMLAttr(fmt.Sprintf("%s://%s", dpHttp.Protocol, dpHttp.Domain.RootDomain)),
-		IdDomain:         template.HTMLAttr(fmt.Sprintf("%s://%s", dpHttp.Protocol, dpHttp.Domain.IdDomain)),
-		DiscordPlaysUser: dpMeUser,
-		Projects:         dpHttp.projectData,
-	})
-	fillPageWithFuncMap(rw, "body", templatePage, funcMap, data)
-	_, _ = rw.Write([]byte("</body></html>"))
+func (dpHttp *DiscordPlaysHttp) generatePage(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody, meta *structure.PageMeta, page string, data interface{}) {
+	dpHttp.generatePageWithStatus(rw, req, http.StatusOK, dpUser, meta, page, data)
 }
 
-func fillPage(w io.Writer, name string, tempStr string, data interface{}) {
-	tmpl, err := template.New(name).Parse(tempStr)
+// generatePageWithStatus renders the page into the layout, nothing is written
+// until the whole page has rendered so template errors become a 500
+func (dpHttp *DiscordPlaysHttp) generatePageWithStatus(rw http.ResponseWriter, req *http.Request, status int, dpUser *structure.DiscordMeBody, meta *structure.PageMeta, page string, data interface{}) {
+	tmpl, err := dpHttp.templates.Page(page)
 	if err != nil {
-		log.Printf("[Http::GeneratePage] Parse: %v\n", err)
+		log.Printf("[Http::GeneratePage] Failed to load %s: %s\n", page, err)
+		http.Error(rw, "500 Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 	if data == nil {
-		err = tmpl.Execute(w, struct{}{})
-	} else {
-		err = tmpl.Execute(w, data)
-	}
-	if err != nil {
-		log.Printf("[Http::GeneratePage] Execute: %v\n", err)
+		data = struct{}{}
 	}
-}
 
-func fillPageWithFuncMap(w io.Writer, name string, tempStr string, funcMap template.FuncMap, data interface{}) {
-	tmpl := template.New(name)
-	tmpl.Funcs(funcMap)
-	_, err := tmpl.Parse(tempStr)
+	dpHttp.rwSync.RLock()
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, pageLayout{
+		Head: struct {
+			*structure.PageMeta
+			RootDomain string
+		}{
+			PageMeta:   meta,
+			RootDomain: dpHttp.rootUrl(),
+		},
+		Nav: struct {
+			RootDomain       template.HTMLAttr
+			IdDomain         template.HTMLAttr
+			DiscordPlaysUser *structure.DiscordPlaysUserBody
+			Projects         []*structure.ProjectItem
+			Community        bool
+			LinkedLogins     map[string]LinkedAccountProvider
+		}{
+			RootDomain:       template.HTMLAttr(fmt.Sprintf("%s://%s", dpHttp.Protocol, dpHttp.Domain.RootDomain)),
+			IdDomain:         template.HTMLAttr(fmt.Sprintf("%s://%s", dpHttp.Protocol, dpHttp.Domain.IdDomain)),
+			DiscordPlaysUser: dpHttp.convertToDpBody(dpUser),
+			Projects:         dpHttp.projectData,
+			Community:        dpHttp.communityGuild != "",
+			LinkedLogins:     dpHttp.linkProviders,
+		},
+		Body: data,
+	})
+	dpHttp.rwSync.RUnlock()
 	if err != nil {
-		log.Printf("[Http::GeneratePage Parse: %v\n", err)
+		log.Printf("[Http::GeneratePage] Failed to render %s: %s\n", page, err)
+		http.Error(rw, "500 Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	err = tmpl.Execute(w, data)
-	if err != nil {
-		log.Printf("[Http::GeneratePage Parse: %v\n", err)
-	}
+
+	b := dpHttp.fillCsrfTokens(rw, req, buf.Bytes())
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(status)
+	_, _ = rw.Write(b)
 }
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"github.com/discord-plays/website/structure"
+	"github.com/gorilla/mux"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDb(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "db.sqlite")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newLoginTestSession is the cookie for a logged in session and a form token
+// for it
+func newLoginTestSession(t *testing.T, dpHttp *DiscordPlaysHttp, loginSession *structure.LoginSession) (*http.Cookie, string) {
+	t.Helper()
+	if err := dpHttp.db.Create(loginSession).Error; err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "https://dp.test/", nil)
+	rec := httptest.NewRecorder()
+	sess, _, _ := dpHttp.dpSess.CheckLogin(req)
+	sess.Values["loginSession"] = loginSession.Id
+	dpHttp.dpSess.SetUser(sess, &structure.DiscordMeBody{Id: loginSession.UserId, LoggedInUntil: time.Now().Add(dpHttp.dpSess.IdleTimeout(sess))})
+	token := dpHttp.csrfToken(rec, req)
+	if err := sess.Save(req, rec); err != nil {
+		t.Fatal(err)
+	}
+	cookies := rec.Result().Cookies()
+	return cookies[len(cookies)-1], token
+}
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		name      string
+		returnUrl string
+		want      string
+	}{
+		{"back to a project", "https://minesweeper.dp.test/play", "https://minesweeper.dp.test/play"},
+		{"without a return url", "", "https://dp.test"},
+		{"return url on another site", "https://evil.test/", "https://dp.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dpHttp := newSessionTestHttp(t)
+			dpHttp.db = newTestDb(t, &structure.LoginSession{})
+			now := time.Now()
+			cookie, token := newLoginTestSession(t, dpHttp, &structure.LoginSession{Id: "login", UserId: "1", RenewedAt: now, ExpiresAt: now.Add(time.Hour)})
+
+			router := mux.NewRouter()
+			router.HandleFunc("/logout", dpHttp.logout).Methods(http.MethodPost)
+			router.Use(dpHttp.csrfMiddleware)
+
+			// The nav sends the form like this from pages with the referrer turned off
+			form := url.Values{csrfFieldName: {token}, "return": {tt.returnUrl}}
+			req := httptest.NewRequest(http.MethodPost, "https://minesweeper.dp.test/logout", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Origin", "null")
+			req.AddCookie(cookie)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusSeeOther {
+				t.Fatalf("POST /logout = %d, want %d", rec.Code, http.StatusSeeOther)
+			}
+			wantLocation := "https://id.dp.test/logout/done?return=" + url.QueryEscape(tt.want)
+			if got := rec.Header().Get("Location"); got != wantLocation {
+				t.Errorf("POST /logout redirected to %q, want %q", got, wantLocation)
+			}
+
+			var count int64
+			dpHttp.db.Model(&structure.LoginSession{}).Where("id = ?", "login").Count(&count)
+			if count != 0 {
+				t.Errorf("the login session is still in the database")
+			}
+			after := httptest.NewRequest(http.MethodGet, "https://dp.test/", nil)
+			for _, c := range rec.Result().Cookies() {
+				after.AddCookie(c)
+			}
+			if _, _, ok := dpHttp.dpSess.CheckLogin(after); ok {
+				t.Errorf("the saved session is still logged in")
+			}
+		})
+	}
+}
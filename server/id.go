@@ -1,151 +1,198 @@
 package server
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"github.com/discord-plays/website/structure"
 	"github.com/gorilla/mux"
-	"io"
-	"io/ioutil"
+	"github.com/gorilla/sessions"
+	"log"
 	"net/http"
 	"strings"
-	"time"
 )
 
 const (
 	LoginFrameStart = "<!DOCTYPE html><html><head><script>window.opener.postMessage({user:"
 	LoginFrameEnd   = "},\"%s://%s\");window.close();</script></head></html>"
 	CheckFrameStart = "<!DOCTYPE html><html><head><script>window.onload=function(){window.parent.postMessage({user:"
-	CheckFrameEnd   = "},\"%s://%s\");window.addEventListener(\"message\",function(evt){if (evt.origin.endsWith(\"%s\")) {if(evt.data.logout==\"bye\"){console.log(\"logging out\");}}});}</script></head></html>"
+	CheckFrameEnd   = "},\"%[1]s://%[2]s\");if(window.BroadcastChannel){new BroadcastChannel(\"discord-plays-session\").onmessage=function(evt){if(evt.data.logout==\"bye\"){window.parent.postMessage({logout:\"bye\"},\"%[1]s://%[2]s\");}};}}</script></head></html>"
+	ListenFrame     = "<!DOCTYPE html><html><head><script>if(window.BroadcastChannel){new BroadcastChannel(\"discord-plays-session\").onmessage=function(evt){if(evt.data.logout==\"bye\"){window.parent.postMessage({logout:\"bye\"},\"%[1]s://%[2]s\");}};}</script></head></html>"
+	LogoutFrame     = "<!DOCTYPE html><html><head><script>if(window.BroadcastChannel){new BroadcastChannel(\"discord-plays-session\").postMessage({logout:\"bye\"});}location.replace(%s);</script></head></html>"
 )
 
 func SetupDiscordPlaysId(dpHttp *DiscordPlaysHttp, router *mux.Router) {
 	router.HandleFunc("/", func(rw http.ResponseWriter, req *http.Request) {
 		http.Redirect(rw, req, fmt.Sprintf("%s://%s", dpHttp.Protocol, dpHttp.Domain.RootDomain), http.StatusTemporaryRedirect)
 	})
-	router.HandleFunc("/login", func(rw http.ResponseWriter, req *http.Request) {
-		redirectDomain := req.URL.Query().Get("redirect")
+	dpHttp.rateLimit("login", router.HandleFunc("/login", func(rw http.ResponseWriter, req *http.Request) {
+		redirectDomain := dpHttp.allowedRedirectHost(req.URL.Query().Get("redirect"))
 		sess, _, ok := dpHttp.dpSess.CheckLogin(req)
 		sess.Values["RedirectDomain"] = redirectDomain
-		_ = sess.Save(req, rw)
-		if ok {
-			if redirectDomain == "" {
-				redirectDomain = dpHttp.Domain.RootDomain
-			}
+		delete(sess.Values, "LoginReturn")
+		community := req.URL.Query().Get("community") == "1" && dpHttp.communityGuild != ""
+		if ok && !community {
+			_ = sess.Save(req, rw)
 			http.Redirect(rw, req, fmt.Sprintf("%s://%s", dpHttp.Protocol, redirectDomain), http.StatusTemporaryRedirect)
 			return
 		}
 
-		state := dpHttp.dpSess.GetStateToken(sess)
-		_ = sess.Save(req, rw)
-		http.Redirect(rw, req, dpHttp.oAuthConf.AuthCodeURL(state), http.StatusTemporaryRedirect)
-	})
-	router.HandleFunc("/check", func(rw http.ResponseWriter, req *http.Request) {
-		parentDomain := req.URL.Query().Get("parent")
+		if provider := req.URL.Query().Get("provider"); provider != "" && !ok {
+			sess.Values["RememberMe"] = req.URL.Query().Get("remember") == "1"
+			dpHttp.startLinkedAccountFlow(rw, req, sess, provider, "login")
+			return
+		}
+		if ok {
+			// Keep the remember me choice from the current login
+			dpHttp.startProviderLogin(rw, req, sess, discordScopeGuildMembersRead)
+			return
+		}
+		sess.Values["RememberMe"] = req.URL.Query().Get("remember") == "1"
+		if community {
+			dpHttp.startProviderLogin(rw, req, sess, discordScopeGuildMembersRead)
+			return
+		}
+		dpHttp.startProviderLogin(rw, req, sess)
+	}))
+	dpHttp.rateLimit("check", router.HandleFunc("/check", func(rw http.ResponseWriter, req *http.Request) {
+		parentDomain := dpHttp.allowedRedirectHost(req.URL.Query().Get("parent"))
 		_, meBody, ok := dpHttp.dpSess.CheckLogin(req)
 		if ok {
 			dpBody := dpHttp.convertToDpBody(meBody)
 			j, err := json.Marshal(dpBody)
 			if err != nil {
-				rw.WriteHeader(http.StatusInternalServerError)
-				_, _ = rw.Write([]byte(err.Error()))
+				log.Printf("[Http::Id] Failed to encode user: %s\n", err)
+				dpHttp.writeError(rw, req, http.StatusInternalServerError)
 				return
 			}
 
-			if parentDomain != dpHttp.Domain.RootDomain && !strings.HasSuffix(parentDomain, dpHttp.Domain.ProjectDomain) {
-				parentDomain = dpHttp.Domain.RootDomain
+			token, _, err := dpHttp.issueIdentityToken(meBody)
+			if err != nil {
+				log.Printf("[Http::Id] Failed to issue identity token: %s\n", err)
+				dpHttp.writeError(rw, req, http.StatusInternalServerError)
+				return
 			}
+			t, _ := json.Marshal(token)
 
 			_, _ = rw.Write([]byte(CheckFrameStart))
 			_, _ = rw.Write(j)
-			_, _ = rw.Write([]byte(fmt.Sprintf(CheckFrameEnd, dpHttp.Protocol, parentDomain, dpHttp.Domain.ProjectDomain)))
+			_, _ = rw.Write([]byte(",token:"))
+			_, _ = rw.Write(t)
+			_, _ = rw.Write([]byte(fmt.Sprintf(CheckFrameEnd, dpHttp.Protocol, parentDomain)))
 			return
 		}
 		_, _ = rw.Write([]byte{})
+	}))
+	// Pages which got the user from /api/me load this instead of the check
+	// frame so logouts in other tabs still reach them
+	dpHttp.rateLimit("check", router.HandleFunc("/listen", func(rw http.ResponseWriter, req *http.Request) {
+		parentDomain := dpHttp.allowedRedirectHost(req.URL.Query().Get("parent"))
+		_, _ = rw.Write([]byte(fmt.Sprintf(ListenFrame, dpHttp.Protocol, parentDomain)))
+	})).Methods(http.MethodGet)
+	router.HandleFunc("/logout/done", func(rw http.ResponseWriter, req *http.Request) {
+		// Every open check and listen frame hears this and tells its page the user has gone
+		j, _ := json.Marshal(dpHttp.safeReturnUrl(req.URL.Query().Get("return")))
+		rw.Header().Set("Cache-Control", "no-store")
+		_, _ = rw.Write([]byte(fmt.Sprintf(LogoutFrame, j)))
+	}).Methods(http.MethodGet)
+	dpHttp.apiRoute(dpHttp.rateLimit("check", router.HandleFunc("/token", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "no-store")
+		_, meBody, ok := dpHttp.dpSess.CheckLogin(req)
+		if !ok {
+			writeJsonError(rw, req, http.StatusUnauthorized, "not logged in")
+			return
+		}
+		token, exp, err := dpHttp.issueIdentityToken(meBody)
+		if err != nil {
+			log.Printf("[Http::Id] Failed to issue identity token: %s\n", err)
+			writeJsonError(rw, req, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		_ = json.NewEncoder(rw).Encode(struct {
+			Token     string `json:"token"`
+			ExpiresAt int64  `json:"expires_at"`
+		}{
+			Token:     token,
+			ExpiresAt: exp.Unix(),
+		})
+	})))
+	router.HandleFunc("/.well-known/jwks.json", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "public, max-age=300")
+		rw.Header().Set("Access-Control-Allow-Origin", "*")
+		_ = json.NewEncoder(rw).Encode(dpHttp.tokens.JWKS())
 	})
-	router.HandleFunc("/auth/callback", func(rw http.ResponseWriter, req *http.Request) {
+	dpHttp.rateLimit("login", router.HandleFunc("/auth/callback", func(rw http.ResponseWriter, req *http.Request) {
 		sess, _, ok := dpHttp.dpSess.CheckLogin(req)
-		if !ok {
+		// Logged in users come back here after asking for extra scopes
+		if !ok || req.FormValue("code") != "" {
 			if req.FormValue("state") != dpHttp.dpSess.GetStateToken(sess) {
-				rw.WriteHeader(http.StatusBadRequest)
-				_, _ = rw.Write([]byte("State does not match."))
+				dpHttp.writeError(rw, req, http.StatusBadRequest)
 				return
 			}
 			// Step 3: We exchange the code we got for an access token
 			// Then we can use the access token to do actions, limited to scopes we requested
-			token, err := dpHttp.oAuthConf.Exchange(context.Background(), req.FormValue("code"))
+			token, err := dpHttp.identity.Exchange(context.Background(), req.FormValue("code"))
 
 			if err != nil {
-				rw.WriteHeader(http.StatusInternalServerError)
-				_, _ = rw.Write([]byte(err.Error()))
+				log.Printf("[Http::Id] Failed to exchange code: %s\n", err)
+				dpHttp.writeError(rw, req, http.StatusInternalServerError)
 				return
 			}
 
 			// Step 4: Use the access token, here we use it to get the logged in user's info.
-			res, err := dpHttp.oAuthConf.Client(context.Background(), token).Get("https://discord.com/api/users/@me")
-
-			if err != nil || res.StatusCode != 200 {
-				rw.WriteHeader(http.StatusInternalServerError)
-				if err != nil {
-					_, _ = rw.Write([]byte(err.Error()))
-				} else {
-					_, _ = rw.Write([]byte(res.Status))
-				}
-				return
-			}
-
-			defer func(Body io.ReadCloser) {
-				_ = Body.Close()
-			}(res.Body)
-
-			body, err := ioutil.ReadAll(res.Body)
+			meBody, err := dpHttp.identity.Profile(context.Background(), token)
 			if err != nil {
-				rw.WriteHeader(http.StatusInternalServerError)
-				_, _ = rw.Write([]byte(err.Error()))
+				log.Printf("[Http::Id] Failed to fetch profile: %s\n", err)
+				dpHttp.writeError(rw, req, http.StatusInternalServerError)
 				return
 			}
-
-			meBody := &structure.DiscordMeBody{}
-			err = json.Unmarshal(body, meBody)
+			err = dpHttp.startLogin(sess, meBody, "", token)
 			if err != nil {
-				rw.WriteHeader(http.StatusInternalServerError)
-				_, _ = rw.Write([]byte(err.Error()))
+				log.Printf("[Http::Id] Failed to start login: %s\n", err)
+				dpHttp.writeError(rw, req, http.StatusInternalServerError)
 				return
 			}
-			meBody.LoggedInUntil = time.Now().Add(2 * time.Hour)
-			s := new(bytes.Buffer)
-			g := gob.NewEncoder(s)
-			err = g.Encode(meBody)
-			if err != nil {
-				sess.Values["dpUser"] = []byte{}
-			} else {
-				sess.Values["dpUser"] = s.Bytes()
-			}
-			_ = sess.Save(req, rw)
 
-			dpBody := dpHttp.convertToDpBody(meBody)
-			j, err := json.Marshal(dpBody)
-			if err != nil {
-				rw.WriteHeader(http.StatusInternalServerError)
-				_, _ = rw.Write([]byte(err.Error()))
-				return
-			}
-
-			var redirectDomain = ""
-			if redirectableDomain, ok := sess.Values["RedirectDomain"].(string); ok {
-				redirectDomain = redirectableDomain
-			}
+			dpHttp.finishLogin(rw, req, sess, meBody)
+		}
+	}))
+}
 
-			if redirectDomain != dpHttp.Domain.RootDomain && !strings.HasSuffix(redirectDomain, dpHttp.Domain.ProjectDomain) {
-				redirectDomain = dpHttp.Domain.RootDomain
-			}
+// finishLogin sends the user back to where the login started, the popup
+// window tells the page which opened it who logged in
+func (dpHttp *DiscordPlaysHttp) finishLogin(rw http.ResponseWriter, req *http.Request, sess *sessions.Session, meBody *structure.DiscordMeBody) {
+	// Full page logins such as OAuth authorisation go straight back to where they started
+	if loginReturn, ok := sess.Values["LoginReturn"].(string); ok && strings.HasPrefix(loginReturn, "/") && !strings.HasPrefix(loginReturn, "//") {
+		delete(sess.Values, "LoginReturn")
+		_ = sess.Save(req, rw)
+		http.Redirect(rw, req, loginReturn, http.StatusSeeOther)
+		return
+	}
+	_ = sess.Save(req, rw)
+
+	dpBody := dpHttp.convertToDpBody(meBody)
+	j, err := json.Marshal(dpBody)
+	if err != nil {
+		log.Printf("[Http::Id] Failed to encode user: %s\n", err)
+		dpHttp.writeError(rw, req, http.StatusInternalServerError)
+		return
+	}
+
+	redirectDomain, _ := sess.Values["RedirectDomain"].(string)
+	redirectDomain = dpHttp.allowedRedirectHost(redirectDomain)
+
+	_, _ = rw.Write([]byte(LoginFrameStart))
+	_, _ = rw.Write(j)
+	_, _ = rw.Write([]byte(fmt.Sprintf(LoginFrameEnd, dpHttp.Protocol, redirectDomain)))
+}
 
-			_, _ = rw.Write([]byte(LoginFrameStart))
-			_, _ = rw.Write(j)
-			_, _ = rw.Write([]byte(fmt.Sprintf(LoginFrameEnd, dpHttp.Protocol, redirectDomain)))
-		}
-	})
+// startProviderLogin sends the user to the identity provider to log in, the
+// state token is checked again in /auth/callback. Extra scopes are asked for on
+// top of the identify scope.
+func (dpHttp *DiscordPlaysHttp) startProviderLogin(rw http.ResponseWriter, req *http.Request, sess *sessions.Session, extraScopes ...string) {
+	state := dpHttp.dpSess.GetStateToken(sess)
+	_ = sess.Save(req, rw)
+	http.Redirect(rw, req, dpHttp.identity.AuthCodeURL(state, extraScopes...), http.StatusTemporaryRedirect)
 }
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"fmt"
+	"github.com/discord-plays/website/structure"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm/clause"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxIdeaTitleLength       = 100
+	maxIdeaDescriptionLength = 2000
+)
+
+func SetupDiscordPlaysIdeas(dpHttp *DiscordPlaysHttp, rootRouter *mux.Router, adminRouter *mux.Router) {
+	rootRouter.HandleFunc("/ideas", func(rw http.ResponseWriter, req *http.Request) {
+		_, dpUser, ok := dpHttp.dpSess.CheckLogin(req)
+		userId := ""
+		if ok {
+			userId = dpUser.Id
+		}
+		ideas, err := dpHttp.loadBotIdeas(false, userId)
+		if err != nil {
+			log.Printf("[Http::Ideas] Failed to load ideas: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		dpHttp.generatePage(rw, req, dpUser, dpHttp.newPageMeta(req, "Bot Ideas", "Suggest ideas for new Discord Plays bots and vote for the ones you want to see made."), "ideas.go.html", struct {
+			Ideas    []*structure.BotIdea
+			SignedIn bool
+			Error    string
+		}{
+			Ideas:    ideas,
+			SignedIn: ok,
+			Error:    req.URL.Query().Get("error"),
+		})
+	}).Methods(http.MethodGet)
+	rootRouter.HandleFunc("/ideas", func(rw http.ResponseWriter, req *http.Request) {
+		_, dpUser, ok := dpHttp.dpSess.CheckLogin(req)
+		if !ok {
+			dpHttp.writeError(rw, req, http.StatusForbidden)
+			return
+		}
+		title := strings.TrimSpace(req.PostFormValue("title"))
+		description := strings.TrimSpace(req.PostFormValue("description"))
+		if title == "" || description == "" {
+			http.Redirect(rw, req, "/ideas?error=missing", http.StatusSeeOther)
+			return
+		}
+		if utf8.RuneCountInString(title) > maxIdeaTitleLength || utf8.RuneCountInString(description) > maxIdeaDescriptionLength {
+			http.Redirect(rw, req, "/ideas?error=length", http.StatusSeeOther)
+			return
+		}
+		idea := &structure.BotIdea{
+			Title:       title,
+			Description: description,
+			AuthorId:    dpUser.Id,
+			AuthorName:  dpUser.DisplayName(),
+			Status:      structure.BotIdeaOpen,
+		}
+		if err := dpHttp.db.Create(idea).Error; err != nil {
+			log.Printf("[Http::Ideas] Failed to save idea: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		// Authors vote for their own idea by default
+		dpHttp.db.Create(&structure.BotIdeaVote{BotIdeaID: idea.ID, UserId: dpUser.Id})
+		http.Redirect(rw, req, "/ideas", http.StatusSeeOther)
+	}).Methods(http.MethodPost)
+	rootRouter.HandleFunc("/ideas/{id:[0-9]+}/vote", func(rw http.ResponseWriter, req *http.Request) {
+		_, dpUser, ok := dpHttp.dpSess.CheckLogin(req)
+		if !ok {
+			dpHttp.writeError(rw, req, http.StatusForbidden)
+			return
+		}
+		idea, ok := getBotIdeaFromVars(dpHttp, req)
+		if !ok || idea.Hidden {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		vote := &structure.BotIdeaVote{BotIdeaID: idea.ID, UserId: dpUser.Id}
+		if req.PostFormValue("remove") == "1" {
+			dpHttp.db.Delete(vote)
+		} else {
+			dpHttp.db.Clauses(clause.OnConflict{DoNothing: true}).Create(vote)
+		}
+		http.Redirect(rw, req, fmt.Sprintf("/ideas#idea-%d", idea.ID), http.StatusSeeOther)
+	}).Methods(http.MethodPost)
+
+	adminRouter.HandleFunc("/ideas", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		ideas, err := dpHttp.loadBotIdeas(true, "")
+		if err != nil {
+			log.Printf("[Http::Ideas] Failed to load ideas: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		dpHttp.generatePage(rw, req, dpUser, dpHttp.newPageMeta(req, "Discord Plays Admin - Ideas", ""), "admin-ideas.go.html", struct {
+			Ideas    []*structure.BotIdea
+			Statuses []string
+			Projects []*structure.ProjectItem
+		}{
+			Ideas:    ideas,
+			Statuses: structure.BotIdeaStatuses,
+			Projects: getProjectList(dpHttp),
+		})
+	})).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/ideas/{id:[0-9]+}", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		idea, ok := getBotIdeaFromVars(dpHttp, req)
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		status := req.PostFormValue("status")
+		if !structure.IsBotIdeaStatus(status) {
+			dpHttp.writeError(rw, req, http.StatusBadRequest)
+			return
+		}
+		idea.Status = status
+		idea.Hidden = req.PostFormValue("hidden") == "1"
+		idea.ProjectItemID = nil
+		idea.ProjectItem = nil
+		if status == structure.BotIdeaShipped {
+			if project, ok := getProjectItemFromName(dpHttp, req.PostFormValue("project")); ok {
+				idea.ProjectItemID = &project.ID
+			}
+		}
+		if err := dpHttp.db.Model(idea).Select("Status", "Hidden", "ProjectItemID").Updates(idea).Error; err != nil {
+			log.Printf("[Http::Ideas] Failed to update idea: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(rw, req, fmt.Sprintf("/ideas#idea-%d", idea.ID), http.StatusSeeOther)
+	})).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/ideas/{id:[0-9]+}/delete", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		idea, ok := getBotIdeaFromVars(dpHttp, req)
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		dpHttp.db.Where("bot_idea_id = ?", idea.ID).Delete(&structure.BotIdeaVote{})
+		dpHttp.db.Delete(idea)
+		http.Redirect(rw, req, "/ideas", http.StatusSeeOther)
+	})).Methods(http.MethodPost)
+}
+
+func getBotIdeaFromVars(dpHttp *DiscordPlaysHttp, req *http.Request) (*structure.BotIdea, bool) {
+	id, err := strconv.ParseUint(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	idea := &structure.BotIdea{}
+	if dpHttp.db.Limit(1).Find(idea, id).RowsAffected == 0 {
+		return nil, false
+	}
+	return idea, true
+}
+
+// loadBotIdeas returns ideas ordered by vote count, filling in whether userId
+// has voted for each of them
+func (dpHttp *DiscordPlaysHttp) loadBotIdeas(showHidden bool, userId string) ([]*structure.BotIdea, error) {
+	var ideas []*structure.BotIdea
+	q := dpHttp.db.Preload("ProjectItem").Order("created_at desc")
+	if !showHidden {
+		q = q.Where("hidden = ?", false)
+	}
+	if err := q.Find(&ideas).Error; err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		BotIdeaID uint
+		Count     int
+	}
+	if err := dpHttp.db.Model(&structure.BotIdeaVote{}).Select("bot_idea_id, count(*) as count").Group("bot_idea_id").Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	countMap := make(map[uint]int)
+	for _, c := range counts {
+		countMap[c.BotIdeaID] = c.Count
+	}
+
+	votedMap := make(map[uint]bool)
+	if userId != "" {
+		var voted []uint
+		dpHttp.db.Model(&structure.BotIdeaVote{}).Where("user_id = ?", userId).Pluck("bot_idea_id", &voted)
+		for _, v := range voted {
+			votedMap[v] = true
+		}
+	}
+
+	for _, i := range ideas {
+		i.Votes = countMap[i.ID]
+		i.Voted = votedMap[i.ID]
+	}
+	sort.SliceStable(ideas, func(i, j int) bool {
+		return ideas[i].Votes > ideas[j].Votes
+	})
+	return ideas, nil
+}
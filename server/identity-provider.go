@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/discord-plays/website/structure"
+	"github.com/ravener/discord-oauth2"
+	"golang.org/x/oauth2"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const discordScopeGuildMembersRead = "guilds.members.read"
+
+// IdentityProvider is where users log in, the Discord provider is used unless
+// IDENTITY_PROVIDER picks another one
+type IdentityProvider interface {
+	// Name is shown to users on the login pages
+	Name() string
+	// AuthCodeURL is where users are sent to log in, they come back to
+	// /auth/callback with a code and the same state
+	AuthCodeURL(state string, extraScopes ...string) string
+	Exchange(ctx context.Context, code string) (*oauth2.Token, error)
+	// Refresh swaps a refresh token for a new token, failures caused by the
+	// refresh token being revoked are *oauth2.RetrieveError
+	Refresh(ctx context.Context, refreshToken string) (*oauth2.Token, error)
+	Profile(ctx context.Context, token *oauth2.Token) (*structure.DiscordMeBody, error)
+}
+
+func (dpHttp *DiscordPlaysHttp) newIdentityProvider(name string) IdentityProvider {
+	redirectUrl := dpHttp.idUrl() + "/auth/callback"
+	switch name {
+	case "", "discord":
+		return &discordProvider{
+			conf: &oauth2.Config{
+				RedirectURL:  redirectUrl,
+				ClientID:     os.Getenv("DISCORD_CLIENT"),
+				ClientSecret: os.Getenv("DISCORD_SECRET"),
+				Scopes:       []string{discord.ScopeIdentify},
+				Endpoint:     discord.Endpoint,
+			},
+			communityGuild: dpHttp.communityGuild,
+		}
+	case "mock":
+		log.Printf("[Http::Identity] Using the mock identity provider, anyone can log in as anyone\n")
+		return &mockProvider{authorizeUrl: dpHttp.idUrl() + "/mock/authorize", redirectUrl: redirectUrl}
+	}
+	log.Fatalf("[Http::Identity] Unknown identity provider: %s\n", name)
+	return nil
+}
+
+type discordProvider struct {
+	conf           *oauth2.Config
+	communityGuild string
+}
+
+func (d *discordProvider) Name() string {
+	return "Discord"
+}
+
+func (d *discordProvider) AuthCodeURL(state string, extraScopes ...string) string {
+	var opts []oauth2.AuthCodeOption
+	if len(extraScopes) > 0 {
+		scopes := append(append([]string{}, d.conf.Scopes...), extraScopes...)
+		opts = append(opts, oauth2.SetAuthURLParam("scope", strings.Join(scopes, " ")))
+	}
+	return d.conf.AuthCodeURL(state, opts...)
+}
+
+func (d *discordProvider) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
+	return d.conf.Exchange(ctx, code)
+}
+
+func (d *discordProvider) Refresh(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+	return d.conf.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+}
+
+func (d *discordProvider) Profile(ctx context.Context, token *oauth2.Token) (*structure.DiscordMeBody, error) {
+	meBody := &structure.DiscordMeBody{}
+	err := d.getJson(ctx, token, "https://discord.com/api/users/@me", meBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// Membership is only known for users who opted into the community check
+	if d.communityGuild != "" && hasDiscordScope(token, discordScopeGuildMembersRead) {
+		meBody.Member, err = d.communityMembership(ctx, token)
+		if err != nil {
+			log.Printf("[Http::Identity] Failed to check community membership: %s\n", err)
+		}
+	}
+	return meBody, nil
+}
+
+func (d *discordProvider) getJson(ctx context.Context, token *oauth2.Token, url string, v interface{}) error {
+	res, err := d.conf.Client(ctx, token).Get(url)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return errors.New(res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// communityMembership checks if the user is in the community server, Discord
+// answers 404 for users who are not in it
+func (d *discordProvider) communityMembership(ctx context.Context, token *oauth2.Token) (bool, error) {
+	res, err := d.conf.Client(ctx, token).Get(fmt.Sprintf("https://discord.com/api/users/@me/guilds/%s/member", d.communityGuild))
+	if err != nil {
+		return false, err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected status: %s", res.Status)
+}
+
+// hasDiscordScope checks the scopes Discord granted with the token
+func hasDiscordScope(token *oauth2.Token, scope string) bool {
+	granted, _ := token.Extra("scope").(string)
+	for _, s := range strings.Fields(granted) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,345 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/discord-plays/website/structure"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// LinkedAccountProvider is another site users can link to their Discord Plays
+// account, once linked it can also be used to log in
+type LinkedAccountProvider interface {
+	Name() string
+	AuthCodeURL(state string) string
+	Exchange(ctx context.Context, code string) (*oauth2.Token, error)
+	Account(ctx context.Context, token *oauth2.Token) (*structure.LinkedIdentity, error)
+}
+
+func (dpHttp *DiscordPlaysHttp) newLinkProviders() map[string]LinkedAccountProvider {
+	providers := make(map[string]LinkedAccountProvider)
+	if _, ok := dpHttp.identity.(*mockProvider); ok {
+		providers["github"] = &mockLinkProvider{
+			name:         "GitHub",
+			authorizeUrl: dpHttp.idUrl() + "/mock/link/github",
+			redirectUrl:  dpHttp.idUrl() + "/link/github/callback",
+		}
+		return providers
+	}
+	if githubClient := os.Getenv("GITHUB_CLIENT"); githubClient != "" {
+		providers["github"] = &githubProvider{conf: &oauth2.Config{
+			RedirectURL:  dpHttp.idUrl() + "/link/github/callback",
+			ClientID:     githubClient,
+			ClientSecret: os.Getenv("GITHUB_SECRET"),
+			Endpoint:     github.Endpoint,
+		}}
+	}
+	return providers
+}
+
+type githubProvider struct {
+	conf *oauth2.Config
+}
+
+func (g *githubProvider) Name() string {
+	return "GitHub"
+}
+
+func (g *githubProvider) AuthCodeURL(state string) string {
+	return g.conf.AuthCodeURL(state)
+}
+
+func (g *githubProvider) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
+	return g.conf.Exchange(ctx, code)
+}
+
+func (g *githubProvider) Account(ctx context.Context, token *oauth2.Token) (*structure.LinkedIdentity, error) {
+	res, err := g.conf.Client(ctx, token).Get("https://api.github.com/user")
+	if err != nil {
+		return nil, err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New(res.Status)
+	}
+
+	var user struct {
+		Id      int64  `json:"id"`
+		Login   string `json:"login"`
+		HtmlUrl string `json:"html_url"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+	return &structure.LinkedIdentity{
+		Subject:    strconv.FormatInt(user.Id, 10),
+		Username:   user.Login,
+		ProfileUrl: user.HtmlUrl,
+	}, nil
+}
+
+func SetupDiscordPlaysLinkedAccounts(dpHttp *DiscordPlaysHttp, idRouter *mux.Router) {
+	idRouter.HandleFunc("/account", func(rw http.ResponseWriter, req *http.Request) {
+		sess, dpUser, ok := dpHttp.dpSess.CheckLogin(req)
+		if !ok {
+			sess.Values["LoginReturn"] = "/account"
+			dpHttp.startProviderLogin(rw, req, sess)
+			return
+		}
+		user, err := dpHttp.findUser(dpUser.Id)
+		if err != nil {
+			// Logins from before the users table was added
+			user, err = dpHttp.saveUser(dpUser)
+			if err != nil {
+				log.Printf("[Http::Accounts] Failed to save user: %s\n", err)
+				dpHttp.writeError(rw, req, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		linked := make(map[string]bool)
+		for _, i := range user.LinkedIdentities {
+			linked[i.Provider] = true
+		}
+		var unlinked []string
+		for k := range dpHttp.linkProviders {
+			if !linked[k] {
+				unlinked = append(unlinked, k)
+			}
+		}
+
+		meta := dpHttp.newPageMeta(req, "My Account", "")
+		meta.NoIndex = true
+		dpHttp.generatePage(rw, req, dpUser, meta, "account.go.html", struct {
+			Profile    *structure.DiscordPlaysUserBody
+			User       *structure.User
+			Identities []structure.LinkedIdentity
+			Unlinked   []string
+			Providers  map[string]LinkedAccountProvider
+			RootDomain string
+			Error      string
+		}{
+			Profile:    dpHttp.convertToDpBody(dpUser),
+			User:       user,
+			Identities: user.LinkedIdentities,
+			Unlinked:   unlinked,
+			Providers:  dpHttp.linkProviders,
+			RootDomain: dpHttp.rootUrl(),
+			Error:      req.URL.Query().Get("error"),
+		})
+	}).Methods(http.MethodGet)
+	idRouter.HandleFunc("/account/link/{provider}", func(rw http.ResponseWriter, req *http.Request) {
+		sess, _, ok := dpHttp.dpSess.CheckLogin(req)
+		if !ok {
+			dpHttp.writeError(rw, req, http.StatusForbidden)
+			return
+		}
+		dpHttp.startLinkedAccountFlow(rw, req, sess, mux.Vars(req)["provider"], "link")
+	}).Methods(http.MethodPost)
+	idRouter.HandleFunc("/account/link/{provider}/confirm", func(rw http.ResponseWriter, req *http.Request) {
+		sess, dpUser, ok := dpHttp.dpSess.CheckLogin(req)
+		if !ok {
+			dpHttp.writeError(rw, req, http.StatusForbidden)
+			return
+		}
+		identity := &structure.LinkedIdentity{}
+		j, _ := sess.Values["PendingLink"].([]byte)
+		delete(sess.Values, "PendingLink")
+		_ = sess.Save(req, rw)
+		if json.Unmarshal(j, identity) != nil || identity.Provider != mux.Vars(req)["provider"] {
+			http.Redirect(rw, req, "/account?error=expired", http.StatusSeeOther)
+			return
+		}
+		if req.PostFormValue("action") != "link" {
+			http.Redirect(rw, req, "/account", http.StatusSeeOther)
+			return
+		}
+		user, err := dpHttp.findUser(dpUser.Id)
+		if err != nil {
+			http.Redirect(rw, req, "/account?error=expired", http.StatusSeeOther)
+			return
+		}
+		for _, i := range user.LinkedIdentities {
+			if i.Provider == identity.Provider {
+				http.Redirect(rw, req, "/account?error=already", http.StatusSeeOther)
+				return
+			}
+		}
+		identity.UserID = user.ID
+		// The unique index stops the same account being linked to two users
+		if err := dpHttp.db.Create(identity).Error; err != nil {
+			http.Redirect(rw, req, "/account?error=taken", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(rw, req, "/account", http.StatusSeeOther)
+	}).Methods(http.MethodPost)
+	idRouter.HandleFunc("/account/privacy", func(rw http.ResponseWriter, req *http.Request) {
+		_, dpUser, ok := dpHttp.dpSess.CheckLogin(req)
+		if !ok {
+			dpHttp.writeError(rw, req, http.StatusForbidden)
+			return
+		}
+		// A map is used so turning settings off is saved too
+		err := dpHttp.db.Model(&structure.User{}).Where("discord_id = ?", dpUser.Id).Updates(map[string]interface{}{
+			"public_profile":       req.PostFormValue("publicProfile") == "1",
+			"show_linked_accounts": req.PostFormValue("showLinkedAccounts") == "1",
+			"bot_lookup":           req.PostFormValue("botLookup") == "1",
+		}).Error
+		if err != nil {
+			log.Printf("[Http::Accounts] Failed to save privacy settings: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(rw, req, "/account", http.StatusSeeOther)
+	}).Methods(http.MethodPost)
+	idRouter.HandleFunc("/account/unlink/{id:[0-9]+}", func(rw http.ResponseWriter, req *http.Request) {
+		_, dpUser, ok := dpHttp.dpSess.CheckLogin(req)
+		if !ok {
+			dpHttp.writeError(rw, req, http.StatusForbidden)
+			return
+		}
+		id, err := strconv.ParseUint(mux.Vars(req)["id"], 10, 64)
+		if err != nil {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		user, err := dpHttp.findUser(dpUser.Id)
+		if err == nil {
+			dpHttp.db.Where("id = ? AND user_id = ?", id, user.ID).Delete(&structure.LinkedIdentity{})
+		}
+		http.Redirect(rw, req, "/account", http.StatusSeeOther)
+	}).Methods(http.MethodPost)
+	dpHttp.rateLimit("login", idRouter.HandleFunc("/link/{provider}/callback", func(rw http.ResponseWriter, req *http.Request) {
+		sess, dpUser, loggedIn := dpHttp.dpSess.CheckLogin(req)
+		key := mux.Vars(req)["provider"]
+		provider, ok := dpHttp.linkProviders[key]
+		state, _ := sess.Values["LinkState"].(string)
+		intent, _ := sess.Values["LinkIntent"].(string)
+		delete(sess.Values, "LinkState")
+		delete(sess.Values, "LinkIntent")
+		if !ok || state == "" || req.FormValue("state") != state {
+			_ = sess.Save(req, rw)
+			dpHttp.writeError(rw, req, http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+		defer cancel()
+		token, err := provider.Exchange(ctx, req.FormValue("code"))
+		var identity *structure.LinkedIdentity
+		if err == nil {
+			identity, err = provider.Account(ctx, token)
+		}
+		if err != nil {
+			log.Printf("[Http::Accounts] Failed to load %s account: %s\n", key, err)
+			_ = sess.Save(req, rw)
+			dpHttp.generateLinkError(rw, req, dpUser, fmt.Sprintf("%s could not be reached, please try again.", provider.Name()))
+			return
+		}
+		identity.Provider = key
+
+		existing := &structure.LinkedIdentity{}
+		found := dpHttp.db.First(existing, "provider = ? AND subject = ?", key, identity.Subject).Error == nil
+
+		if intent == "login" {
+			if !found {
+				_ = sess.Save(req, rw)
+				dpHttp.generateLinkError(rw, req, nil, fmt.Sprintf("No Discord Plays account is linked to this %s account. Log in with Discord and link it from your account page first.", provider.Name()))
+				return
+			}
+			user := &structure.User{}
+			if err := dpHttp.db.First(user, existing.UserID).Error; err != nil {
+				_ = sess.Save(req, rw)
+				dpHttp.generateLinkError(rw, req, nil, "This account could not be found.")
+				return
+			}
+			meBody := user.MeBody()
+			if err := dpHttp.startLogin(sess, meBody, key, token); err != nil {
+				log.Printf("[Http::Accounts] Failed to start login: %s\n", err)
+				dpHttp.writeError(rw, req, http.StatusInternalServerError)
+				return
+			}
+			dpHttp.finishLogin(rw, req, sess, meBody)
+			return
+		}
+
+		if !loggedIn {
+			_ = sess.Save(req, rw)
+			dpHttp.generateLinkError(rw, req, nil, "You need to be logged in to link an account.")
+			return
+		}
+		user, err := dpHttp.findUser(dpUser.Id)
+		if err != nil {
+			_ = sess.Save(req, rw)
+			http.Redirect(rw, req, "/account?error=expired", http.StatusSeeOther)
+			return
+		}
+		if found {
+			_ = sess.Save(req, rw)
+			if existing.UserID != user.ID {
+				// Never move an account between users, that would merge them by accident
+				http.Redirect(rw, req, "/account?error=taken", http.StatusSeeOther)
+				return
+			}
+			dpHttp.db.Model(existing).Updates(map[string]interface{}{"username": identity.Username, "profile_url": identity.ProfileUrl})
+			http.Redirect(rw, req, "/account", http.StatusSeeOther)
+			return
+		}
+
+		// Ask before linking in case the browser was logged in to someone else's account
+		j, _ := json.Marshal(identity)
+		sess.Values["PendingLink"] = j
+		_ = sess.Save(req, rw)
+		meta := dpHttp.newPageMeta(req, "Link "+provider.Name(), "")
+		meta.NoIndex = true
+		dpHttp.generatePage(rw, req, dpUser, meta, "account-link.go.html", struct {
+			Profile  *structure.DiscordPlaysUserBody
+			Identity *structure.LinkedIdentity
+			Provider string
+		}{
+			Profile:  dpHttp.convertToDpBody(dpUser),
+			Identity: identity,
+			Provider: provider.Name(),
+		})
+	}).Methods(http.MethodGet))
+}
+
+// startLinkedAccountFlow sends the user to a linked account provider, intent is
+// "link" to add the account or "login" to log in with it
+func (dpHttp *DiscordPlaysHttp) startLinkedAccountFlow(rw http.ResponseWriter, req *http.Request, sess *sessions.Session, key string, intent string) {
+	provider, ok := dpHttp.linkProviders[key]
+	if !ok {
+		dpHttp.notFound(rw, req)
+		return
+	}
+	state := uuid.NewString()
+	sess.Values["LinkState"] = state
+	sess.Values["LinkIntent"] = intent
+	_ = sess.Save(req, rw)
+	http.Redirect(rw, req, provider.AuthCodeURL(state), http.StatusSeeOther)
+}
+
+func (dpHttp *DiscordPlaysHttp) generateLinkError(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody, message string) {
+	meta := dpHttp.newPageMeta(req, "Linked accounts", "")
+	meta.NoIndex = true
+	dpHttp.generatePageWithStatus(rw, req, http.StatusBadRequest, dpUser, meta, "account-error.go.html", struct {
+		Message string
+	}{
+		Message: message,
+	})
+}
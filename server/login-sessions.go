@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/discord-plays/website/structure"
+	"github.com/google/uuid"
+	"github.com/gorilla/sessions"
+	"golang.org/x/oauth2"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// startLogin records a new login after the provider callback, provider is
+// empty for the main identity provider. The caller still needs to save the
+// session.
+func (dpHttp *DiscordPlaysHttp) startLogin(sess *sessions.Session, meBody *structure.DiscordMeBody, provider string, token *oauth2.Token) error {
+	if provider == "" {
+		if _, err := dpHttp.saveUser(meBody); err != nil {
+			return err
+		}
+	}
+	sealed, err := dpHttp.dpSess.sealRefreshToken(token.RefreshToken)
+	if err != nil {
+		return err
+	}
+	// Logging in again, for example to add the community check, replaces the
+	// previous login
+	if id, ok := sess.Values["loginSession"].(string); ok {
+		dpHttp.db.Delete(&structure.LoginSession{}, "id = ?", id)
+	}
+
+	remember, _ := sess.Values["RememberMe"].(bool)
+	now := time.Now()
+	loginSession := &structure.LoginSession{
+		Id:           uuid.NewString(),
+		UserId:       meBody.Id,
+		Provider:     provider,
+		RefreshToken: sealed,
+		RememberMe:   remember,
+		RenewedAt:    now,
+		ExpiresAt:    now.Add(dpHttp.dpSess.absoluteTimeout),
+	}
+	err = dpHttp.db.Create(loginSession).Error
+	if err != nil {
+		return err
+	}
+
+	// Tidy up logins which ran out without logging out
+	dpHttp.db.Where("expires_at < ?", now).Delete(&structure.LoginSession{})
+
+	sess.Values["loginSession"] = loginSession.Id
+	meBody.LoggedInUntil = loginIdleExpiry(now.Add(dpHttp.dpSess.IdleTimeout(sess)), loginSession)
+	dpHttp.dpSess.SetUser(sess, meBody)
+	return nil
+}
+
+// endLogin removes the login from the session and the database, the caller
+// still needs to save the session
+func (dpHttp *DiscordPlaysHttp) endLogin(sess *sessions.Session) {
+	if id, ok := sess.Values["loginSession"].(string); ok {
+		dpHttp.db.Delete(&structure.LoginSession{}, "id = ?", id)
+	}
+	delete(sess.Values, "dpUser")
+	delete(sess.Values, "loginSession")
+	delete(sess.Values, "RememberMe")
+}
+
+// renewLoginMiddleware ends logins which have been logged out somewhere else
+// and slides the login forward while the user is active, the session is only
+// renewed once half of the idle timeout has been used
+func (dpHttp *DiscordPlaysHttp) renewLoginMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		sess, meBody, ok := dpHttp.dpSess.CheckLogin(req)
+		if ok {
+			dpHttp.checkLogin(rw, req, sess, meBody)
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// checkLogin ends the login once its row is gone, handlers later in the
+// request get the same session so they see the user as logged out straight away
+func (dpHttp *DiscordPlaysHttp) checkLogin(rw http.ResponseWriter, req *http.Request, sess *sessions.Session, meBody *structure.DiscordMeBody) {
+	id, ok := sess.Values["loginSession"].(string)
+	if !ok {
+		// Logins from before refresh tokens were kept just run out
+		return
+	}
+	if !dpHttp.loginSessionActive(id) {
+		dpHttp.endLogin(sess)
+		_ = sess.Save(req, rw)
+		return
+	}
+	if time.Until(meBody.LoggedInUntil) < dpHttp.dpSess.IdleTimeout(sess)/2 {
+		dpHttp.renewLogin(rw, req, sess, meBody, id)
+	}
+}
+
+func (dpHttp *DiscordPlaysHttp) loginSessionActive(id string) bool {
+	var count int64
+	dpHttp.db.Model(&structure.LoginSession{}).Where("id = ? AND expires_at > ?", id, time.Now()).Count(&count)
+	return count > 0
+}
+
+func (dpHttp *DiscordPlaysHttp) renewLogin(rw http.ResponseWriter, req *http.Request, sess *sessions.Session, meBody *structure.DiscordMeBody, id string) {
+	// Discord replaces the refresh token on every use so only one request per
+	// login can renew at a time
+	defer dpHttp.renewLocks.lock(id)()
+
+	loginSession := &structure.LoginSession{}
+	if dpHttp.db.First(loginSession, "id = ?", id).Error != nil || time.Now().After(loginSession.ExpiresAt) {
+		dpHttp.endLogin(sess)
+		_ = sess.Save(req, rw)
+		return
+	}
+
+	// Another request might have just renewed this login
+	if time.Since(loginSession.RenewedAt) > time.Minute {
+		profile, err := dpHttp.refreshProfile(req.Context(), loginSession)
+		if err != nil {
+			var retrieveErr *oauth2.RetrieveError
+			if errors.As(err, &retrieveErr) {
+				// The refresh token has been revoked
+				log.Printf("[Http::Sessions] Ending login for %s: %s\n", loginSession.UserId, err)
+				dpHttp.endLogin(sess)
+				_ = sess.Save(req, rw)
+				return
+			}
+			log.Printf("[Http::Sessions] Failed to refresh profile: %s\n", err)
+			return
+		}
+		if profile != nil {
+			meBody = profile
+		}
+	}
+
+	meBody.LoggedInUntil = loginIdleExpiry(time.Now().Add(dpHttp.dpSess.IdleTimeout(sess)), loginSession)
+	dpHttp.dpSess.SetUser(sess, meBody)
+	_ = sess.Save(req, rw)
+}
+
+// renewLocks has a lock for each login session being renewed, so requests for
+// other logins don't wait on this login's round trip to Discord
+type renewLocks struct {
+	mutex *sync.Mutex
+	locks map[string]*renewLock
+}
+
+type renewLock struct {
+	mutex *sync.Mutex
+	users int
+}
+
+func newRenewLocks() *renewLocks {
+	return &renewLocks{mutex: &sync.Mutex{}, locks: make(map[string]*renewLock)}
+}
+
+// lock waits for the login session's lock and returns the function to unlock
+// it, the lock is removed once nothing is using it
+func (r *renewLocks) lock(id string) func() {
+	r.mutex.Lock()
+	l, ok := r.locks[id]
+	if !ok {
+		l = &renewLock{mutex: &sync.Mutex{}}
+		r.locks[id] = l
+	}
+	l.users++
+	r.mutex.Unlock()
+
+	l.mutex.Lock()
+	return func() {
+		l.mutex.Unlock()
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		l.users--
+		if l.users == 0 {
+			delete(r.locks, id)
+		}
+	}
+}
+
+// refreshProfile swaps the stored refresh token for a new one and loads
+// the latest profile, nil is returned if the login has no refresh token
+func (dpHttp *DiscordPlaysHttp) refreshProfile(ctx context.Context, loginSession *structure.LoginSession) (*structure.DiscordMeBody, error) {
+	if loginSession.Provider != "" {
+		// Logins through linked accounts use the profile from the last Discord login
+		user, err := dpHttp.findUser(loginSession.UserId)
+		if err != nil {
+			return nil, err
+		}
+		loginSession.RenewedAt = time.Now()
+		dpHttp.db.Model(loginSession).Update("RenewedAt", loginSession.RenewedAt)
+		return user.MeBody(), nil
+	}
+
+	refreshToken, keyIndex, err := dpHttp.dpSess.openRefreshToken(loginSession.RefreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("open refresh token: %w", err)
+	}
+	if refreshToken == "" {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	token, err := dpHttp.identity.Refresh(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken != "" && token.RefreshToken != refreshToken {
+		refreshToken, keyIndex = token.RefreshToken, -1
+	}
+	// New tokens and tokens sealed with a retired key are sealed with the
+	// newest key
+	if keyIndex != 0 {
+		sealed, err := dpHttp.dpSess.sealRefreshToken(refreshToken)
+		if err != nil {
+			return nil, fmt.Errorf("seal refresh token: %w", err)
+		}
+		loginSession.RefreshToken = sealed
+	}
+	loginSession.RenewedAt = time.Now()
+	err = dpHttp.db.Model(loginSession).Select("RefreshToken", "RenewedAt").Updates(loginSession).Error
+	if err != nil {
+		return nil, fmt.Errorf("save refresh token: %w", err)
+	}
+	meBody, err := dpHttp.identity.Profile(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dpHttp.saveUser(meBody); err != nil {
+		log.Printf("[Http::Sessions] Failed to save user: %s\n", err)
+	}
+	return meBody, nil
+}
+
+func loginIdleExpiry(t time.Time, loginSession *structure.LoginSession) time.Time {
+	if t.After(loginSession.ExpiresAt) {
+		return loginSession.ExpiresAt
+	}
+	return t
+}
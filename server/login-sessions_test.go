@@ -0,0 +1,84 @@
+package server
+
+import (
+	"github.com/discord-plays/website/structure"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRenewLoginMiddlewareEndsRevokedLogins(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(dpHttp *DiscordPlaysHttp)
+		want   bool
+	}{
+		{"active login", func(dpHttp *DiscordPlaysHttp) {}, true},
+		{"logged out on another device", func(dpHttp *DiscordPlaysHttp) {
+			dpHttp.db.Delete(&structure.LoginSession{}, "id = ?", "login")
+		}, false},
+		{"past the absolute timeout", func(dpHttp *DiscordPlaysHttp) {
+			dpHttp.db.Model(&structure.LoginSession{}).Where("id = ?", "login").Update("expires_at", time.Now().Add(-time.Minute))
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dpHttp := newSessionTestHttp(t)
+			dpHttp.db = newTestDb(t, &structure.LoginSession{})
+			now := time.Now()
+			cookie, _ := newLoginTestSession(t, dpHttp, &structure.LoginSession{Id: "login", UserId: "1", RenewedAt: now, ExpiresAt: now.Add(time.Hour)})
+			tt.change(dpHttp)
+
+			router := mux.NewRouter()
+			router.HandleFunc("/", func(rw http.ResponseWriter, req *http.Request) {
+				if _, _, ok := dpHttp.dpSess.CheckLogin(req); !ok {
+					rw.WriteHeader(http.StatusUnauthorized)
+				}
+			})
+			router.Use(dpHttp.renewLoginMiddleware)
+
+			req := httptest.NewRequest(http.MethodGet, "https://dp.test/", nil)
+			req.AddCookie(cookie)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if got := rec.Code == http.StatusOK; got != tt.want {
+				t.Errorf("logged in = %v, want %v", got, tt.want)
+			}
+			// The cookie is only rewritten when the login ends
+			if got := len(rec.Result().Cookies()) == 0; got != tt.want {
+				t.Errorf("cookie kept = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenewLocks(t *testing.T) {
+	r := newRenewLocks()
+	unlockA := r.lock("a")
+	unlockB := r.lock("b")
+	if len(r.locks) != 2 {
+		t.Fatalf("%d locks held, want 2", len(r.locks))
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		defer r.lock("a")()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("a second lock on the same login did not wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlockA()
+	<-waited
+	unlockB()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.locks) != 0 {
+		t.Errorf("%d locks left after unlocking, want 0", len(r.locks))
+	}
+}
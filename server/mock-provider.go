@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/discord-plays/website/structure"
+	"github.com/gorilla/mux"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// mockProvider lets anyone log in as a made up Discord user without talking to
+// Discord, it is only meant for local development and tests
+type mockProvider struct {
+	authorizeUrl string
+	redirectUrl  string
+}
+
+// mockUser is the fake profile, the code and tokens are just this encoded
+type mockUser struct {
+	Id            string `json:"id"`
+	Username      string `json:"username"`
+	GlobalName    string `json:"global_name"`
+	Discriminator string `json:"discriminator"`
+	Member        bool   `json:"member"`
+}
+
+func (m *mockProvider) Name() string {
+	return "Mock Discord"
+}
+
+func (m *mockProvider) AuthCodeURL(state string, extraScopes ...string) string {
+	return m.authorizeUrl + "?" + url.Values{
+		"state": {state},
+		"scope": {strings.Join(extraScopes, " ")},
+	}.Encode()
+}
+
+func (m *mockProvider) Exchange(_ context.Context, code string) (*oauth2.Token, error) {
+	if _, err := decodeMockUser(code); err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{
+		AccessToken:  code,
+		RefreshToken: code,
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(time.Hour),
+	}, nil
+}
+
+func (m *mockProvider) Refresh(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+	token, err := m.Exchange(ctx, refreshToken)
+	if err != nil {
+		return nil, &oauth2.RetrieveError{ErrorCode: "invalid_grant", ErrorDescription: err.Error()}
+	}
+	return token, nil
+}
+
+func (m *mockProvider) Profile(_ context.Context, token *oauth2.Token) (*structure.DiscordMeBody, error) {
+	u, err := decodeMockUser(token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	return &structure.DiscordMeBody{
+		Id:            u.Id,
+		Username:      u.Username,
+		GlobalName:    u.GlobalName,
+		Discriminator: u.Discriminator,
+		Member:        u.Member,
+	}, nil
+}
+
+func encodeMockUser(u *mockUser) string {
+	j, _ := json.Marshal(u)
+	return base64.RawURLEncoding.EncodeToString(j)
+}
+
+func decodeMockUser(code string) (*mockUser, error) {
+	j, err := base64.RawURLEncoding.DecodeString(code)
+	if err != nil {
+		return nil, err
+	}
+	u := &mockUser{}
+	if json.Unmarshal(j, u) != nil || u.Id == "" || u.Username == "" {
+		return nil, errors.New("invalid mock user")
+	}
+	return u, nil
+}
+
+// SetupDiscordPlaysMockProvider adds the fake Discord and linked account login
+// pages when the mock identity provider is in use
+func SetupDiscordPlaysMockProvider(dpHttp *DiscordPlaysHttp, idRouter *mux.Router) {
+	mock, ok := dpHttp.identity.(*mockProvider)
+	if !ok {
+		return
+	}
+
+	idRouter.HandleFunc("/mock/authorize", func(rw http.ResponseWriter, req *http.Request) {
+		presets := []*mockUser{
+			{Id: "100000000000000001", Username: "wumpus", GlobalName: "Wumpus", Discriminator: "0", Member: true},
+			{Id: "100000000000000002", Username: "clyde", Discriminator: "1234"},
+		}
+		for _, a := range dpHttp.dpAdmins {
+			if a != "" {
+				presets = append(presets, &mockUser{Id: a, Username: "admin" + a, GlobalName: "Admin " + a, Discriminator: "0", Member: true})
+			}
+		}
+		meta := dpHttp.newPageMeta(req, "Mock login", "")
+		meta.NoIndex = true
+		dpHttp.generatePage(rw, req, nil, meta, "mock-login.go.html", struct {
+			State     string
+			Community bool
+			Presets   []*mockUser
+		}{
+			State:     req.URL.Query().Get("state"),
+			Community: strings.Contains(req.URL.Query().Get("scope"), discordScopeGuildMembersRead),
+			Presets:   presets,
+		})
+	}).Methods(http.MethodGet)
+	idRouter.HandleFunc("/mock/authorize", func(rw http.ResponseWriter, req *http.Request) {
+		u := &mockUser{
+			Id:            strings.TrimSpace(req.PostFormValue("id")),
+			Username:      strings.TrimSpace(req.PostFormValue("username")),
+			GlobalName:    strings.TrimSpace(req.PostFormValue("global_name")),
+			Discriminator: strings.TrimSpace(req.PostFormValue("discriminator")),
+			Member:        req.PostFormValue("member") == "on",
+		}
+		if u.Id == "" || u.Username == "" {
+			dpHttp.writeError(rw, req, http.StatusBadRequest)
+			return
+		}
+		if u.Discriminator == "" {
+			u.Discriminator = "0"
+		}
+		http.Redirect(rw, req, mock.redirectUrl+"?"+url.Values{
+			"code":  {encodeMockUser(u)},
+			"state": {req.PostFormValue("state")},
+		}.Encode(), http.StatusSeeOther)
+	}).Methods(http.MethodPost)
+
+	idRouter.HandleFunc("/mock/link/{provider}", func(rw http.ResponseWriter, req *http.Request) {
+		provider, ok := dpHttp.linkProviders[mux.Vars(req)["provider"]].(*mockLinkProvider)
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		_, dpUser, _ := dpHttp.dpSess.CheckLogin(req)
+		meta := dpHttp.newPageMeta(req, "Mock "+provider.name, "")
+		meta.NoIndex = true
+		dpHttp.generatePage(rw, req, dpUser, meta, "mock-link.go.html", struct {
+			Provider string
+			State    string
+		}{
+			Provider: provider.name,
+			State:    req.URL.Query().Get("state"),
+		})
+	}).Methods(http.MethodGet)
+	idRouter.HandleFunc("/mock/link/{provider}", func(rw http.ResponseWriter, req *http.Request) {
+		provider, ok := dpHttp.linkProviders[mux.Vars(req)["provider"]].(*mockLinkProvider)
+		u := &mockUser{
+			Id:       strings.TrimSpace(req.PostFormValue("id")),
+			Username: strings.TrimSpace(req.PostFormValue("username")),
+		}
+		if !ok || u.Id == "" || u.Username == "" {
+			dpHttp.writeError(rw, req, http.StatusBadRequest)
+			return
+		}
+		http.Redirect(rw, req, provider.redirectUrl+"?"+url.Values{
+			"code":  {encodeMockUser(u)},
+			"state": {req.PostFormValue("state")},
+		}.Encode(), http.StatusSeeOther)
+	}).Methods(http.MethodPost)
+}
+
+// mockLinkProvider stands in for linked account providers such as GitHub when
+// the mock identity provider is in use
+type mockLinkProvider struct {
+	name         string
+	authorizeUrl string
+	redirectUrl  string
+}
+
+func (m *mockLinkProvider) Name() string {
+	return m.name
+}
+
+func (m *mockLinkProvider) AuthCodeURL(state string) string {
+	return m.authorizeUrl + "?" + url.Values{"state": {state}}.Encode()
+}
+
+func (m *mockLinkProvider) Exchange(_ context.Context, code string) (*oauth2.Token, error) {
+	if _, err := decodeMockUser(code); err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{AccessToken: code, TokenType: "Bearer"}, nil
+}
+
+func (m *mockLinkProvider) Account(_ context.Context, token *oauth2.Token) (*structure.LinkedIdentity, error) {
+	u, err := decodeMockUser(token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	return &structure.LinkedIdentity{Subject: u.Id, Username: u.Username}, nil
+}
@@ -0,0 +1,343 @@
+package server
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/discord-plays/website/structure"
+	"github.com/gorilla/mux"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"gorm.io/gorm"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	newsPageSize      = 10
+	newsFeedSize      = 20
+	newsTimeFormInput = "2006-01-02T15:04"
+)
+
+var (
+	markdownRenderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+	slugInvalidChars = regexp.MustCompile("[^a-z0-9]+")
+)
+
+func SetupDiscordPlaysNews(dpHttp *DiscordPlaysHttp, rootRouter *mux.Router, adminRouter *mux.Router) {
+	rootRouter.HandleFunc("/news", func(rw http.ResponseWriter, req *http.Request) {
+		_, dpUser, _ := dpHttp.dpSess.CheckLogin(req)
+		page, err := strconv.Atoi(req.URL.Query().Get("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		var total int64
+		if err := publishedNews(dpHttp.db).Model(&structure.NewsPost{}).Count(&total).Error; err != nil {
+			log.Printf("[Http::News] Failed to count posts: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		var posts []*structure.NewsPost
+		if err := publishedNews(dpHttp.db).Preload("Projects").Offset((page - 1) * newsPageSize).Limit(newsPageSize).Find(&posts).Error; err != nil {
+			log.Printf("[Http::News] Failed to load posts: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		pages := int((total + newsPageSize - 1) / newsPageSize)
+		if page > 1 && page > pages {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		meta := dpHttp.newPageMeta(req, "Discord Plays News", "Launches, events and updates from the Discord Plays team.")
+		if page > 1 {
+			meta.Canonical = fmt.Sprintf("%s?page=%d", meta.Canonical, page)
+		}
+		dpHttp.generatePage(rw, req, dpUser, meta, "news.go.html", struct {
+			Posts    []*structure.NewsPost
+			Page     int
+			Pages    int
+			PrevPage int
+			NextPage int
+		}{
+			Posts:    posts,
+			Page:     page,
+			Pages:    pages,
+			PrevPage: page - 1,
+			NextPage: page + 1,
+		})
+	}).Methods(http.MethodGet)
+	rootRouter.HandleFunc("/news/feed.atom", func(rw http.ResponseWriter, req *http.Request) {
+		var posts []*structure.NewsPost
+		if err := publishedNews(dpHttp.db).Limit(newsFeedSize).Find(&posts).Error; err != nil {
+			log.Printf("[Http::News] Failed to load posts: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		_, _ = rw.Write([]byte(xml.Header))
+		enc := xml.NewEncoder(rw)
+		enc.Indent("", "  ")
+		if err := enc.Encode(dpHttp.newsAtomFeed(posts)); err != nil {
+			log.Printf("[Http::News] Failed to write feed: %s\n", err)
+		}
+	}).Methods(http.MethodGet)
+	rootRouter.HandleFunc("/news/{slug}", func(rw http.ResponseWriter, req *http.Request) {
+		_, dpUser, _ := dpHttp.dpSess.CheckLogin(req)
+		post := &structure.NewsPost{}
+		if publishedNews(dpHttp.db).Preload("Projects").Where("slug = ?", mux.Vars(req)["slug"]).Limit(1).Find(post).RowsAffected == 0 {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		meta := dpHttp.newPageMeta(req, post.Title, post.Summary)
+		meta.Type = "article"
+		dpHttp.generatePage(rw, req, dpUser, meta, "news-post.go.html", struct {
+			Post *structure.NewsPost
+		}{
+			Post: post,
+		})
+	}).Methods(http.MethodGet)
+
+	adminRouter.HandleFunc("/news", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		var posts []*structure.NewsPost
+		if err := dpHttp.db.Order("publish_at desc").Find(&posts).Error; err != nil {
+			log.Printf("[Http::News] Failed to load posts: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		dpHttp.generatePage(rw, req, dpUser, dpHttp.newPageMeta(req, "Discord Plays Admin - News", ""), "admin-news.go.html", struct {
+			Posts []*structure.NewsPost
+			Now   time.Time
+		}{
+			Posts: posts,
+			Now:   time.Now(),
+		})
+	})).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/news/new", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		dpHttp.generateNewsEditor(rw, req, dpUser, &structure.NewsPost{PublishAt: time.Now().UTC()}, "")
+	})).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/news", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		post := &structure.NewsPost{AuthorName: dpUser.DisplayName()}
+		saveNewsPost(dpHttp, rw, req, dpUser, post)
+	})).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/news/{id:[0-9]+}", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		post, ok := getNewsPostFromVars(dpHttp, req)
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		dpHttp.generateNewsEditor(rw, req, dpUser, post, "")
+	})).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/news/{id:[0-9]+}", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		post, ok := getNewsPostFromVars(dpHttp, req)
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		saveNewsPost(dpHttp, rw, req, dpUser, post)
+	})).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/news/{id:[0-9]+}/delete", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		post, ok := getNewsPostFromVars(dpHttp, req)
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		_ = dpHttp.db.Model(post).Association("Projects").Clear()
+		dpHttp.db.Unscoped().Delete(post)
+		http.Redirect(rw, req, "/news", http.StatusSeeOther)
+	})).Methods(http.MethodPost)
+}
+
+// publishedNews scopes a query to posts which are visible on the public site
+func publishedNews(db *gorm.DB) *gorm.DB {
+	return db.Where("draft = ? AND publish_at <= ?", false, time.Now().UTC()).Order("publish_at desc")
+}
+
+// loadProjectNews returns the latest published posts tied to a project
+func (dpHttp *DiscordPlaysHttp) loadProjectNews(project *structure.ProjectItem, limit int) []*structure.NewsPost {
+	var posts []*structure.NewsPost
+	err := publishedNews(dpHttp.db).
+		Joins("JOIN news_post_projects ON news_post_projects.news_post_id = news_posts.id").
+		Where("news_post_projects.project_item_id = ?", project.ID).
+		Limit(limit).Find(&posts).Error
+	if err != nil {
+		log.Printf("[Http::News] Failed to load project posts: %s\n", err)
+		return nil
+	}
+	return posts
+}
+
+func getNewsPostFromVars(dpHttp *DiscordPlaysHttp, req *http.Request) (*structure.NewsPost, bool) {
+	id, err := strconv.ParseUint(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	post := &structure.NewsPost{}
+	if dpHttp.db.Preload("Projects").Limit(1).Find(post, id).RowsAffected == 0 {
+		return nil, false
+	}
+	return post, true
+}
+
+func saveNewsPost(dpHttp *DiscordPlaysHttp, rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody, post *structure.NewsPost) {
+	_ = req.ParseForm()
+	post.Title = strings.TrimSpace(req.PostFormValue("title"))
+	post.Summary = strings.TrimSpace(req.PostFormValue("summary"))
+	post.Body = req.PostFormValue("body")
+	post.Draft = req.PostFormValue("draft") == "1"
+	post.Slug = makeSlug(req.PostFormValue("slug"))
+	if post.Slug == "" {
+		post.Slug = makeSlug(post.Title)
+	}
+
+	var projects []*structure.ProjectItem
+	for _, code := range req.PostForm["projects"] {
+		if p, ok := getProjectItemFromName(dpHttp, code); ok {
+			projects = append(projects, p)
+		}
+	}
+	post.Projects = projects
+
+	publishAt, err := time.ParseInLocation(newsTimeFormInput, req.PostFormValue("publishAt"), time.UTC)
+	if err != nil {
+		dpHttp.generateNewsEditor(rw, req, dpUser, post, "The publish time is invalid")
+		return
+	}
+	post.PublishAt = publishAt
+	if post.Title == "" || post.Slug == "" {
+		dpHttp.generateNewsEditor(rw, req, dpUser, post, "A title is required")
+		return
+	}
+	var clash int64
+	dpHttp.db.Model(&structure.NewsPost{}).Where("slug = ? AND id <> ?", post.Slug, post.ID).Count(&clash)
+	if clash > 0 {
+		dpHttp.generateNewsEditor(rw, req, dpUser, post, "Another post already uses this permalink")
+		return
+	}
+
+	err = dpHttp.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Projects").Save(post).Error; err != nil {
+			return err
+		}
+		return tx.Model(post).Association("Projects").Replace(projects)
+	})
+	if err != nil {
+		log.Printf("[Http::News] Failed to save post: %s\n", err)
+		dpHttp.writeError(rw, req, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(rw, req, "/news", http.StatusSeeOther)
+}
+
+func (dpHttp *DiscordPlaysHttp) generateNewsEditor(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody, post *structure.NewsPost, errMsg string) {
+	selected := make(map[uint]bool)
+	for _, p := range post.Projects {
+		selected[p.ID] = true
+	}
+	dpHttp.generatePage(rw, req, dpUser, dpHttp.newPageMeta(req, "Discord Plays Admin - Edit News", ""), "admin-news-edit.go.html", struct {
+		Post      *structure.NewsPost
+		PublishAt string
+		Projects  []*structure.ProjectItem
+		Selected  map[uint]bool
+		Error     string
+	}{
+		Post:      post,
+		PublishAt: post.PublishAt.UTC().Format(newsTimeFormInput),
+		Projects:  getProjectList(dpHttp),
+		Selected:  selected,
+		Error:     errMsg,
+	})
+}
+
+func makeSlug(a string) string {
+	return strings.Trim(slugInvalidChars.ReplaceAllString(strings.ToLower(a), "-"), "-")
+}
+
+// renderMarkdown converts the Markdown body of a post into HTML, raw HTML in
+// the source is escaped by goldmark
+func renderMarkdown(a string) template.HTML {
+	var buf bytes.Buffer
+	if err := markdownRenderer.Convert([]byte(a), &buf); err != nil {
+		log.Printf("[Http::News] Failed to render markdown: %s\n", err)
+		return ""
+	}
+	return template.HTML(buf.String())
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	Id        string      `xml:"id"`
+	Link      atomLink    `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    atomAuthor  `xml:"author"`
+	Summary   string      `xml:"summary,omitempty"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func (dpHttp *DiscordPlaysHttp) newsAtomFeed(posts []*structure.NewsPost) *atomFeed {
+	root := dpHttp.rootUrl()
+	feed := &atomFeed{
+		Title: "Discord Plays News",
+		Id:    root + "/news",
+		Links: []atomLink{
+			{Href: root + "/news", Rel: "alternate", Type: "text/html"},
+			{Href: root + "/news/feed.atom", Rel: "self", Type: "application/atom+xml"},
+		},
+		Entries: make([]atomEntry, 0, len(posts)),
+	}
+	var updated time.Time
+	for _, p := range posts {
+		permalink := fmt.Sprintf("%s/news/%s", root, p.Slug)
+		postUpdated := p.UpdatedAt
+		if postUpdated.Before(p.PublishAt) {
+			postUpdated = p.PublishAt
+		}
+		if postUpdated.After(updated) {
+			updated = postUpdated
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:     p.Title,
+			Id:        permalink,
+			Link:      atomLink{Href: permalink, Rel: "alternate", Type: "text/html"},
+			Published: p.PublishAt.UTC().Format(time.RFC3339),
+			Updated:   postUpdated.UTC().Format(time.RFC3339),
+			Author:    atomAuthor{Name: p.AuthorName},
+			Summary:   p.Summary,
+			Content:   atomContent{Type: "html", Body: string(renderMarkdown(p.Body))},
+		})
+	}
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+	return feed
+}
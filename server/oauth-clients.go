@@ -0,0 +1,154 @@
+package server
+
+import (
+	"github.com/discord-plays/website/structure"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+func setupOAuthClientAdmin(dpHttp *DiscordPlaysHttp, router *mux.Router) {
+	router.HandleFunc("/clients", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		var clients []*structure.OAuthClient
+		if err := dpHttp.db.Order("name asc").Find(&clients).Error; err != nil {
+			log.Printf("[Http::OAuth] Failed to load clients: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		dpHttp.generatePage(rw, req, dpUser, dpHttp.newPageMeta(req, "Discord Plays Admin - OAuth Clients", ""), "admin-clients.go.html", struct {
+			Clients []*structure.OAuthClient
+			Issuer  string
+		}{
+			Clients: clients,
+			Issuer:  dpHttp.idUrl(),
+		})
+	})).Methods(http.MethodGet)
+	router.HandleFunc("/clients/new", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		dpHttp.generateOAuthClientEditor(rw, req, dpUser, &structure.OAuthClient{}, "", "")
+	})).Methods(http.MethodGet)
+	router.HandleFunc("/clients", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		client := &structure.OAuthClient{ClientId: uuid.NewString(), Public: req.PostFormValue("public") == "1"}
+		saveOAuthClient(dpHttp, rw, req, dpUser, client)
+	})).Methods(http.MethodPost)
+	router.HandleFunc("/clients/{id:[0-9]+}", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		client, ok := getOAuthClientFromVars(dpHttp, req)
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		dpHttp.generateOAuthClientEditor(rw, req, dpUser, client, "", "")
+	})).Methods(http.MethodGet)
+	router.HandleFunc("/clients/{id:[0-9]+}", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		client, ok := getOAuthClientFromVars(dpHttp, req)
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		saveOAuthClient(dpHttp, rw, req, dpUser, client)
+	})).Methods(http.MethodPost)
+	router.HandleFunc("/clients/{id:[0-9]+}/secret", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		client, ok := getOAuthClientFromVars(dpHttp, req)
+		if !ok || client.Public {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		secret, err := randomOAuthSecret()
+		if err != nil {
+			log.Printf("[Http::OAuth] Failed to generate client secret: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		client.SecretHash = hashOAuthSecret(secret)
+		if err = dpHttp.db.Model(client).Update("secret_hash", client.SecretHash).Error; err != nil {
+			log.Printf("[Http::OAuth] Failed to save client secret: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		dpHttp.generateOAuthClientEditor(rw, req, dpUser, client, secret, "")
+	})).Methods(http.MethodPost)
+	router.HandleFunc("/clients/{id:[0-9]+}/delete", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		client, ok := getOAuthClientFromVars(dpHttp, req)
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		dpHttp.db.Where("o_auth_client_id = ?", client.ID).Delete(&structure.OAuthConsent{})
+		dpHttp.db.Where("o_auth_client_id = ?", client.ID).Delete(&structure.OAuthAuthCode{})
+		dpHttp.db.Unscoped().Delete(client)
+		http.Redirect(rw, req, "/clients", http.StatusSeeOther)
+	})).Methods(http.MethodPost)
+}
+
+func getOAuthClientFromVars(dpHttp *DiscordPlaysHttp, req *http.Request) (*structure.OAuthClient, bool) {
+	id, err := strconv.ParseUint(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	client := &structure.OAuthClient{}
+	if dpHttp.db.Limit(1).Find(client, id).RowsAffected == 0 {
+		return nil, false
+	}
+	return client, true
+}
+
+func saveOAuthClient(dpHttp *DiscordPlaysHttp, rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody, client *structure.OAuthClient) {
+	client.Name = strings.TrimSpace(req.PostFormValue("name"))
+	client.Disabled = req.PostFormValue("disabled") == "1"
+	uris := strings.Fields(req.PostFormValue("redirectUris"))
+	client.RedirectUris = strings.Join(uris, "\n")
+	if client.Name == "" {
+		dpHttp.generateOAuthClientEditor(rw, req, dpUser, client, "", "A name is required")
+		return
+	}
+	if len(uris) == 0 {
+		dpHttp.generateOAuthClientEditor(rw, req, dpUser, client, "", "At least one redirect URI is required")
+		return
+	}
+	for _, i := range uris {
+		u, err := url.Parse(i)
+		if err != nil || !u.IsAbs() || u.Fragment != "" || (u.Scheme != "https" && u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1") {
+			dpHttp.generateOAuthClientEditor(rw, req, dpUser, client, "", "Redirect URIs must be absolute https addresses without a fragment: "+i)
+			return
+		}
+	}
+
+	// New confidential clients get a secret straight away
+	secret := ""
+	if client.ID == 0 && !client.Public {
+		var err error
+		if secret, err = randomOAuthSecret(); err != nil {
+			log.Printf("[Http::OAuth] Failed to generate client secret: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		client.SecretHash = hashOAuthSecret(secret)
+	}
+	if err := dpHttp.db.Save(client).Error; err != nil {
+		log.Printf("[Http::OAuth] Failed to save client: %s\n", err)
+		dpHttp.writeError(rw, req, http.StatusInternalServerError)
+		return
+	}
+	if secret != "" {
+		dpHttp.generateOAuthClientEditor(rw, req, dpUser, client, secret, "")
+		return
+	}
+	http.Redirect(rw, req, "/clients", http.StatusSeeOther)
+}
+
+func (dpHttp *DiscordPlaysHttp) generateOAuthClientEditor(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody, client *structure.OAuthClient, secret, errMsg string) {
+	dpHttp.generatePage(rw, req, dpUser, dpHttp.newPageMeta(req, "Discord Plays Admin - Edit OAuth Client", ""), "admin-client-edit.go.html", struct {
+		Client *structure.OAuthClient
+		Secret string
+		Issuer string
+		Error  string
+	}{
+		Client: client,
+		Secret: secret,
+		Issuer: dpHttp.idUrl(),
+		Error:  errMsg,
+	})
+}
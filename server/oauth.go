@@ -0,0 +1,484 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/discord-plays/website/structure"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	oauthCodeLifetime        = time.Minute
+	oauthAccessTokenLifetime = time.Hour
+	oauthRequestSessionKey   = "OAuthRequest"
+)
+
+var oauthSupportedScopes = []string{"openid", "profile"}
+
+// oauthAuthorizeRequest is a validated authorization request waiting for the
+// user to give consent, it is kept in the session between the two steps
+type oauthAuthorizeRequest struct {
+	Id            string
+	ClientId      string
+	RedirectUri   string
+	Scope         string
+	State         string
+	Nonce         string
+	CodeChallenge string
+}
+
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func SetupDiscordPlaysOAuth(dpHttp *DiscordPlaysHttp, idRouter *mux.Router, adminRouter *mux.Router) {
+	idRouter.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, req *http.Request) {
+		issuer := dpHttp.idUrl()
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "public, max-age=3600")
+		rw.Header().Set("Access-Control-Allow-Origin", "*")
+		_ = json.NewEncoder(rw).Encode(map[string]interface{}{
+			"issuer":                                issuer,
+			"authorization_endpoint":                issuer + "/oauth/authorize",
+			"token_endpoint":                        issuer + "/oauth/token",
+			"userinfo_endpoint":                     issuer + "/oauth/userinfo",
+			"jwks_uri":                              issuer + "/.well-known/jwks.json",
+			"response_types_supported":              []string{"code"},
+			"grant_types_supported":                 []string{"authorization_code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"ES256"},
+			"scopes_supported":                      oauthSupportedScopes,
+			"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+			"code_challenge_methods_supported":      []string{"S256"},
+			"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "nonce", "preferred_username", "name", "picture", "admin"},
+		})
+	}).Methods(http.MethodGet)
+	idRouter.HandleFunc("/oauth/authorize", func(rw http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		client, ok := dpHttp.getOAuthClient(q.Get("client_id"))
+		if !ok {
+			dpHttp.generateOAuthError(rw, req, "This application is not registered with Discord Plays.")
+			return
+		}
+		redirectUri := q.Get("redirect_uri")
+		if redirectUri == "" && len(client.RedirectUriList()) == 1 {
+			redirectUri = client.RedirectUriList()[0]
+		}
+		if !client.HasRedirectUri(redirectUri) {
+			dpHttp.generateOAuthError(rw, req, "The redirect address does not match the ones registered for this application.")
+			return
+		}
+
+		// From here on errors are sent back to the client application
+		state := q.Get("state")
+		if q.Get("response_type") != "code" {
+			redirectOAuthError(rw, req, redirectUri, state, "unsupported_response_type", "only the code response type is supported")
+			return
+		}
+		scope, ok := normaliseOAuthScope(q.Get("scope"))
+		if !ok {
+			redirectOAuthError(rw, req, redirectUri, state, "invalid_scope", "the openid scope is required")
+			return
+		}
+		if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+			redirectOAuthError(rw, req, redirectUri, state, "invalid_request", "PKCE with the S256 method is required")
+			return
+		}
+
+		authReq := &oauthAuthorizeRequest{
+			Id:            uuid.NewString(),
+			ClientId:      client.ClientId,
+			RedirectUri:   redirectUri,
+			Scope:         scope,
+			State:         state,
+			Nonce:         q.Get("nonce"),
+			CodeChallenge: q.Get("code_challenge"),
+		}
+
+		sess, meBody, ok := dpHttp.dpSess.CheckLogin(req)
+		if !ok {
+			if q.Get("prompt") == "none" {
+				redirectOAuthError(rw, req, redirectUri, state, "login_required", "")
+				return
+			}
+			// Come back here once the Discord login has finished
+			sess.Values["LoginReturn"] = req.URL.RequestURI()
+			dpHttp.startProviderLogin(rw, req, sess)
+			return
+		}
+
+		if q.Get("prompt") != "consent" && dpHttp.hasOAuthConsent(client, meBody.Id, scope) {
+			dpHttp.finishOAuthAuthorize(rw, req, client, authReq, meBody)
+			return
+		}
+		if q.Get("prompt") == "none" {
+			redirectOAuthError(rw, req, redirectUri, state, "consent_required", "")
+			return
+		}
+
+		j, _ := json.Marshal(authReq)
+		sess.Values[oauthRequestSessionKey] = j
+		_ = sess.Save(req, rw)
+
+		rw.Header().Set("X-Frame-Options", "DENY")
+		rw.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+		meta := dpHttp.newPageMeta(req, "Authorise "+client.Name, "")
+		meta.NoIndex = true
+		dpHttp.generatePage(rw, req, meBody, meta, "oauth-consent.go.html", struct {
+			Client    *structure.OAuthClient
+			RequestId string
+			Profile   bool
+			Redirect  string
+		}{
+			Client:    client,
+			RequestId: authReq.Id,
+			Profile:   strings.Contains(" "+scope+" ", " profile "),
+			Redirect:  redirectHost(redirectUri),
+		})
+	}).Methods(http.MethodGet)
+	idRouter.HandleFunc("/oauth/authorize", func(rw http.ResponseWriter, req *http.Request) {
+		sess, meBody, ok := dpHttp.dpSess.CheckLogin(req)
+		if !ok {
+			dpHttp.generateOAuthError(rw, req, "You need to be logged in to authorise an application.")
+			return
+		}
+		authReq := &oauthAuthorizeRequest{}
+		j, _ := sess.Values[oauthRequestSessionKey].([]byte)
+		if json.Unmarshal(j, authReq) != nil || authReq.Id == "" || authReq.Id != req.PostFormValue("request") {
+			dpHttp.generateOAuthError(rw, req, "This authorisation request has expired, please start again from the application.")
+			return
+		}
+		delete(sess.Values, oauthRequestSessionKey)
+		_ = sess.Save(req, rw)
+
+		client, ok := dpHttp.getOAuthClient(authReq.ClientId)
+		if !ok || !client.HasRedirectUri(authReq.RedirectUri) {
+			dpHttp.generateOAuthError(rw, req, "This application is not registered with Discord Plays.")
+			return
+		}
+		if req.PostFormValue("action") != "approve" {
+			redirectOAuthError(rw, req, authReq.RedirectUri, authReq.State, "access_denied", "the user denied the request")
+			return
+		}
+		err := dpHttp.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&structure.OAuthConsent{
+			OAuthClientID: client.ID,
+			UserId:        meBody.Id,
+			Scope:         authReq.Scope,
+		}).Error
+		if err != nil {
+			log.Printf("[Http::OAuth] Failed to save consent: %s\n", err)
+		}
+		dpHttp.finishOAuthAuthorize(rw, req, client, authReq, meBody)
+	}).Methods(http.MethodPost)
+	// Clients call these directly with their own credentials
+	dpHttp.exemptFromCsrf(dpHttp.apiRoute(dpHttp.rateLimit("oauth", idRouter.HandleFunc("/oauth/token", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "no-store")
+		rw.Header().Set("Pragma", "no-cache")
+		if err := req.ParseForm(); err != nil {
+			writeOAuthError(rw, http.StatusBadRequest, "invalid_request", "")
+			return
+		}
+		if req.PostFormValue("grant_type") != "authorization_code" {
+			writeOAuthError(rw, http.StatusBadRequest, "unsupported_grant_type", "")
+			return
+		}
+
+		clientId, clientSecret, basic := req.BasicAuth()
+		if !basic {
+			clientId = req.PostFormValue("client_id")
+			clientSecret = req.PostFormValue("client_secret")
+		}
+		client, ok := dpHttp.getOAuthClient(clientId)
+		if !ok || (!client.Public && !checkOAuthClientSecret(client, clientSecret)) {
+			if basic {
+				rw.Header().Set("WWW-Authenticate", "Basic realm=\"Discord Plays\"")
+			}
+			writeOAuthError(rw, http.StatusUnauthorized, "invalid_client", "")
+			return
+		}
+
+		// Codes are removed as soon as they are looked up so they can only be used once
+		code := &structure.OAuthAuthCode{}
+		codeHash := hashOAuthSecret(req.PostFormValue("code"))
+		err := dpHttp.db.Transaction(func(tx *gorm.DB) error {
+			if tx.Where("code_hash = ?", codeHash).Limit(1).Find(code).RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return tx.Delete(code).Error
+		})
+		if err != nil || code.OAuthClientID != client.ID || code.RedirectUri != req.PostFormValue("redirect_uri") || time.Now().After(code.ExpiresAt) {
+			writeOAuthError(rw, http.StatusBadRequest, "invalid_grant", "")
+			return
+		}
+		if !validPkceVerifier(req.PostFormValue("code_verifier"), code.CodeChallenge) {
+			writeOAuthError(rw, http.StatusBadRequest, "invalid_grant", "the code verifier does not match")
+			return
+		}
+
+		now := time.Now()
+		accessToken, err := dpHttp.tokens.Sign(&structure.AccessTokenClaims{
+			Issuer:    dpHttp.idUrl(),
+			Subject:   code.Subject,
+			Audience:  client.ClientId,
+			ClientId:  client.ClientId,
+			Scope:     code.Scope,
+			TokenUse:  "access",
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(oauthAccessTokenLifetime).Unix(),
+			Username:  code.Username,
+			Name:      code.Name,
+			Avatar:    code.Avatar,
+			Admin:     code.Admin,
+		})
+		if err != nil {
+			log.Printf("[Http::OAuth] Failed to sign access token: %s\n", err)
+			writeOAuthError(rw, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+		idClaims := &structure.IdTokenClaims{
+			Issuer:    dpHttp.idUrl(),
+			Subject:   code.Subject,
+			Audience:  client.ClientId,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(identityTokenLifetime).Unix(),
+			Nonce:     code.Nonce,
+		}
+		if strings.Contains(" "+code.Scope+" ", " profile ") {
+			idClaims.PreferredUsername = code.Username
+			idClaims.Name = code.Name
+			idClaims.Picture = code.Avatar
+			idClaims.Admin = code.Admin
+		}
+		idToken, err := dpHttp.tokens.Sign(idClaims)
+		if err != nil {
+			log.Printf("[Http::OAuth] Failed to sign id token: %s\n", err)
+			writeOAuthError(rw, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(map[string]interface{}{
+			"access_token": accessToken,
+			"token_type":   "Bearer",
+			"expires_in":   int(oauthAccessTokenLifetime.Seconds()),
+			"id_token":     idToken,
+			"scope":        code.Scope,
+		})
+	}).Methods(http.MethodPost))))
+	dpHttp.exemptFromCsrf(dpHttp.apiRoute(dpHttp.rateLimit("oauth", idRouter.HandleFunc("/oauth/userinfo", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Access-Control-Allow-Origin", "*")
+		rw.Header().Set("Access-Control-Allow-Headers", "Authorization")
+		if req.Method == http.MethodOptions {
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+		rw.Header().Set("Cache-Control", "no-store")
+		auth := req.Header.Get("Authorization")
+		claims := &structure.AccessTokenClaims{}
+		if !strings.HasPrefix(auth, "Bearer ") || dpHttp.tokens.Verify(strings.TrimPrefix(auth, "Bearer "), claims) != nil ||
+			claims.TokenUse != "access" || claims.Issuer != dpHttp.idUrl() || time.Now().Unix() >= claims.ExpiresAt {
+			rw.Header().Set("WWW-Authenticate", "Bearer error=\"invalid_token\"")
+			writeOAuthError(rw, http.StatusUnauthorized, "invalid_token", "")
+			return
+		}
+		info := map[string]interface{}{"sub": claims.Subject}
+		if strings.Contains(" "+claims.Scope+" ", " profile ") {
+			info["preferred_username"] = claims.Username
+			info["name"] = claims.Name
+			info["picture"] = claims.Avatar
+			info["admin"] = claims.Admin
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(info)
+	}).Methods(http.MethodGet, http.MethodPost, http.MethodOptions))))
+
+	setupOAuthClientAdmin(dpHttp, adminRouter)
+}
+
+func (dpHttp *DiscordPlaysHttp) getOAuthClient(clientId string) (*structure.OAuthClient, bool) {
+	if clientId == "" {
+		return nil, false
+	}
+	client := &structure.OAuthClient{}
+	if dpHttp.db.Where("client_id = ? AND disabled = ?", clientId, false).Limit(1).Find(client).RowsAffected == 0 {
+		return nil, false
+	}
+	return client, true
+}
+
+func (dpHttp *DiscordPlaysHttp) hasOAuthConsent(client *structure.OAuthClient, userId, scope string) bool {
+	consent := &structure.OAuthConsent{}
+	if dpHttp.db.Where("o_auth_client_id = ? AND user_id = ?", client.ID, userId).Limit(1).Find(consent).RowsAffected == 0 {
+		return false
+	}
+	granted := strings.Fields(consent.Scope)
+	for _, s := range strings.Fields(scope) {
+		found := false
+		for _, g := range granted {
+			if g == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// finishOAuthAuthorize stores a new authorization code and sends the user back
+// to the client application with it
+func (dpHttp *DiscordPlaysHttp) finishOAuthAuthorize(rw http.ResponseWriter, req *http.Request, client *structure.OAuthClient, authReq *oauthAuthorizeRequest, meBody *structure.DiscordMeBody) {
+	code, err := randomOAuthSecret()
+	if err != nil {
+		log.Printf("[Http::OAuth] Failed to generate code: %s\n", err)
+		redirectOAuthError(rw, req, authReq.RedirectUri, authReq.State, "server_error", "")
+		return
+	}
+	dpBody := dpHttp.convertToDpBody(meBody)
+	err = dpHttp.db.Create(&structure.OAuthAuthCode{
+		CodeHash:      hashOAuthSecret(code),
+		OAuthClientID: client.ID,
+		RedirectUri:   authReq.RedirectUri,
+		Scope:         authReq.Scope,
+		Nonce:         authReq.Nonce,
+		CodeChallenge: authReq.CodeChallenge,
+		Subject:       dpBody.Id,
+		Username:      dpBody.Username,
+		Name:          dpBody.DisplayName,
+		Avatar:        dpBody.Avatar,
+		Admin:         dpBody.Admin,
+		ExpiresAt:     time.Now().Add(oauthCodeLifetime),
+	}).Error
+	if err != nil {
+		log.Printf("[Http::OAuth] Failed to save code: %s\n", err)
+		redirectOAuthError(rw, req, authReq.RedirectUri, authReq.State, "server_error", "")
+		return
+	}
+	// Tidy up codes which were never exchanged
+	dpHttp.db.Where("expires_at < ?", time.Now()).Delete(&structure.OAuthAuthCode{})
+
+	params := url.Values{}
+	params.Set("code", code)
+	if authReq.State != "" {
+		params.Set("state", authReq.State)
+	}
+	http.Redirect(rw, req, appendQuery(authReq.RedirectUri, params), http.StatusSeeOther)
+}
+
+func (dpHttp *DiscordPlaysHttp) generateOAuthError(rw http.ResponseWriter, req *http.Request, message string) {
+	_, dpUser, _ := dpHttp.dpSess.CheckLogin(req)
+	meta := dpHttp.newPageMeta(req, "Authorisation failed", "")
+	meta.NoIndex = true
+	dpHttp.generatePageWithStatus(rw, req, http.StatusBadRequest, dpUser, meta, "oauth-error.go.html", struct {
+		Message string
+	}{
+		Message: message,
+	})
+}
+
+func redirectOAuthError(rw http.ResponseWriter, req *http.Request, redirectUri, state, code, description string) {
+	params := url.Values{}
+	params.Set("error", code)
+	if description != "" {
+		params.Set("error_description", description)
+	}
+	if state != "" {
+		params.Set("state", state)
+	}
+	http.Redirect(rw, req, appendQuery(redirectUri, params), http.StatusSeeOther)
+}
+
+func writeOAuthError(rw http.ResponseWriter, status int, code, description string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(oauthError{Error: code, Description: description})
+}
+
+// normaliseOAuthScope removes duplicate scopes and checks they are all
+// supported, openid must always be requested
+func normaliseOAuthScope(a string) (string, bool) {
+	requested := make(map[string]bool)
+	for _, s := range strings.Fields(a) {
+		requested[s] = true
+	}
+	if !requested["openid"] {
+		return "", false
+	}
+	scopes := make([]string, 0, len(requested))
+	for _, s := range oauthSupportedScopes {
+		if requested[s] {
+			scopes = append(scopes, s)
+			delete(requested, s)
+		}
+	}
+	if len(requested) > 0 {
+		return "", false
+	}
+	return strings.Join(scopes, " "), true
+}
+
+func appendQuery(a string, params url.Values) string {
+	u, err := url.Parse(a)
+	if err != nil {
+		return a
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func redirectHost(a string) string {
+	u, err := url.Parse(a)
+	if err != nil {
+		return a
+	}
+	return u.Host
+}
+
+func randomOAuthSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashOAuthSecret(a string) string {
+	h := sha256.Sum256([]byte(a))
+	return hex.EncodeToString(h[:])
+}
+
+// validPkceVerifier checks the verifier against the S256 challenge from the
+// authorize request, RFC 7636 verifiers are 43 to 128 characters long
+func validPkceVerifier(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	h := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(h[:])), []byte(challenge)) == 1
+}
+
+func checkOAuthClientSecret(client *structure.OAuthClient, secret string) bool {
+	if client.SecretHash == "" || secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashOAuthSecret(secret)), []byte(client.SecretHash)) == 1
+}
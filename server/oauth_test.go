@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/discord-plays/website/structure"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func pkceChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func TestValidPkceVerifier(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mJ92K9qXrgp0n0wLzXx6NqqLe1QnF8"
+	challenge := "Ha-CQLYShTaYQnm8GBp1-yScI3jKn0cJqicNNUyw7eM"
+	long := strings.Repeat("a", 128)
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		want      bool
+	}{
+		{"matching verifier", verifier, challenge, true},
+		{"longest verifier", long, pkceChallenge(long), true},
+		{"different verifier", strings.Repeat("b", 43), challenge, false},
+		{"challenge as the verifier", challenge, challenge, false},
+		{"plain method", verifier, verifier, false},
+		{"empty verifier", "", pkceChallenge(""), false},
+		{"short verifier", "abc", pkceChallenge("abc"), false},
+		{"too long verifier", long + "a", pkceChallenge(long + "a"), false},
+		{"empty challenge", verifier, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validPkceVerifier(tt.verifier, tt.challenge); got != tt.want {
+				t.Errorf("validPkceVerifier(%q, %q) = %v, want %v", tt.verifier, tt.challenge, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOAuthTokenCode(t *testing.T) {
+	verifier := strings.Repeat("v", 43)
+	redirectUri := "https://minesweeper.example/callback"
+	tests := []struct {
+		name        string
+		clientId    string
+		verifier    string
+		redirectUri string
+		expired     bool
+		want        int
+	}{
+		{"matching verifier", "minesweeper", verifier, redirectUri, false, http.StatusOK},
+		{"wrong verifier", "minesweeper", strings.Repeat("w", 43), redirectUri, false, http.StatusBadRequest},
+		{"no verifier", "minesweeper", "", redirectUri, false, http.StatusBadRequest},
+		{"different redirect uri", "minesweeper", verifier, "https://minesweeper.example/other", false, http.StatusBadRequest},
+		{"code for another client", "other", verifier, redirectUri, false, http.StatusBadRequest},
+		{"expired code", "minesweeper", verifier, redirectUri, true, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dpHttp := newSessionTestHttp(t)
+			dpHttp.db = newTestDb(t, &structure.OAuthClient{}, &structure.OAuthAuthCode{}, &structure.SigningKey{})
+			dpHttp.rateLimits = newRateLimiter()
+			var err error
+			dpHttp.tokens, err = newTokenSigner(dpHttp.db, [][]byte{newTestSealKey(t)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &structure.OAuthClient{ClientId: "minesweeper", Name: "Minesweeper", RedirectUris: redirectUri, Public: true}
+			dpHttp.db.Create(client)
+			dpHttp.db.Create(&structure.OAuthClient{ClientId: "other", Name: "Other", RedirectUris: redirectUri, Public: true})
+			expiresAt := time.Now().Add(oauthCodeLifetime)
+			if tt.expired {
+				expiresAt = time.Now().Add(-time.Second)
+			}
+			dpHttp.db.Create(&structure.OAuthAuthCode{
+				CodeHash:      hashOAuthSecret("code"),
+				OAuthClientID: client.ID,
+				RedirectUri:   redirectUri,
+				Scope:         "openid",
+				CodeChallenge: pkceChallenge(verifier),
+				Subject:       "user",
+				ExpiresAt:     expiresAt,
+			})
+
+			router := mux.NewRouter()
+			SetupDiscordPlaysOAuth(dpHttp, router, mux.NewRouter())
+			router.Use(dpHttp.csrfMiddleware)
+			exchange := func() *httptest.ResponseRecorder {
+				form := url.Values{
+					"grant_type":    {"authorization_code"},
+					"client_id":     {tt.clientId},
+					"code":          {"code"},
+					"code_verifier": {tt.verifier},
+					"redirect_uri":  {tt.redirectUri},
+				}
+				req := httptest.NewRequest(http.MethodPost, "https://id.dp.test/oauth/token", strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+				return rec
+			}
+
+			rec := exchange()
+			if rec.Code != tt.want {
+				t.Fatalf("POST /oauth/token = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusOK {
+				var body struct {
+					AccessToken string `json:"access_token"`
+					IdToken     string `json:"id_token"`
+				}
+				_ = json.NewDecoder(rec.Body).Decode(&body)
+				claims := &structure.IdTokenClaims{}
+				if err := dpHttp.tokens.Verify(body.IdToken, claims); err != nil || claims.Subject != "user" || claims.Audience != "minesweeper" {
+					t.Errorf("id token claims = %+v, %v", claims, err)
+				}
+			}
+			// Codes can only be tried once, even when the first try failed
+			if rec = exchange(); rec.Code != http.StatusBadRequest {
+				t.Errorf("second POST /oauth/token = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/discord-plays/website/res"
+	"golang.org/x/image/draw"
+	"image"
+	"image/png"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	projectImageCacheControl = "public, max-age=604800"
+	projectPlaceholderSize   = 512
+)
+
+var projectImageSizes = map[int]bool{32: true, 64: true, 128: true, 256: true, 512: true, 1024: true}
+
+// projectImageCache keeps resized variants and placeholders so they are only
+// rendered once, entries are keyed by the hash of the source image
+type projectImageCache struct {
+	mu       *sync.Mutex
+	variants map[string]*projectImage
+	holders  map[string][]byte
+}
+
+type projectImage struct {
+	etag string
+	data []byte
+}
+
+func newProjectImageCache() *projectImageCache {
+	return &projectImageCache{
+		mu:       &sync.Mutex{},
+		variants: make(map[string]*projectImage),
+		holders:  make(map[string][]byte),
+	}
+}
+
+// parseImageSize reads the size query parameter, an empty value means the
+// original image
+func parseImageSize(a string) (int, bool) {
+	if a == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(a)
+	if err != nil || !projectImageSizes[n] {
+		return 0, false
+	}
+	return n, true
+}
+
+// get loads a project image resized to fit inside size by size, projects
+// without the image get a placeholder instead
+func (c *projectImageCache) get(code, name string, size int) (*projectImage, error) {
+	src, err := fs.ReadFile(res.GetAssetsFilesystem(), fmt.Sprintf("projects/%s/%s.png", code, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		src, err = c.placeholder(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(src)
+	hash := hex.EncodeToString(sum[:])
+	if size == 0 {
+		return &projectImage{etag: "\"" + hash[:32] + "\"", data: src}, nil
+	}
+
+	key := fmt.Sprintf("%s-%d", hash, size)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.variants[key]; ok {
+		return v, nil
+	}
+	data, err := resizePng(src, size)
+	if err != nil {
+		return nil, err
+	}
+	v := &projectImage{etag: fmt.Sprintf("\"%s-%d\"", hash[:32], size), data: data}
+	c.variants[key] = v
+	return v, nil
+}
+
+// placeholder renders the site logo on a dark background for projects which
+// have no art yet
+func (c *projectImageCache) placeholder(name string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b, ok := c.holders[name]; ok {
+		return b, nil
+	}
+
+	logoBytes, err := fs.ReadFile(res.GetAssetsFilesystem(), "logo.png")
+	if err != nil {
+		return nil, err
+	}
+	logo, _, err := image.Decode(bytes.NewReader(logoBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, projectPlaceholderSize, projectPlaceholderSize))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(shareCardBackground), image.Point{}, draw.Src)
+	inner := dst.Bounds()
+	if name != "logo" {
+		inner = inner.Inset(projectPlaceholderSize / 4)
+	}
+	draw.CatmullRom.Scale(dst, inner, logo, logo.Bounds(), draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	c.holders[name] = buf.Bytes()
+	return buf.Bytes(), nil
+}
+
+// resizePng scales the image down so neither side is larger than size, images
+// which are already small enough are returned as they are
+func resizePng(src []byte, size int) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	if b.Dx() <= size && b.Dy() <= size {
+		return src, nil
+	}
+	w, h := size, b.Dy()*size/b.Dx()
+	if b.Dy() > b.Dx() {
+		w, h = b.Dx()*size/b.Dy(), size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	// Paletted pixel art can end up larger once resized
+	if buf.Len() >= len(src) {
+		return src, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// etagMatches checks an If-None-Match header which may list several tags
+func etagMatches(header, etag string) bool {
+	for _, i := range strings.Split(header, ",") {
+		i = strings.TrimSpace(i)
+		if i == "*" || strings.TrimPrefix(i, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func serveProjectImage(rw http.ResponseWriter, req *http.Request, img *projectImage) {
+	rw.Header().Set("ETag", img.etag)
+	rw.Header().Set("Cache-Control", projectImageCacheControl)
+	if etagMatches(req.Header.Get("If-None-Match"), img.etag) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	rw.Header().Set("Content-Type", "image/png")
+	rw.Header().Set("Content-Length", strconv.Itoa(len(img.data)))
+	if req.Method == http.MethodHead {
+		return
+	}
+	_, _ = rw.Write(img.data)
+}
@@ -1,14 +1,11 @@
 package server
 
 import (
-	nfHttp "code.mrmelon54.com/melon/neutered-filesystem/http"
 	"fmt"
-	"github.com/discord-plays/website/res"
 	"github.com/discord-plays/website/structure"
 	"github.com/gorilla/mux"
-	"io"
+	"log"
 	"net/http"
-	"strings"
 )
 
 func SetupDiscordPlaysProjects(dpHttp *DiscordPlaysHttp, router *mux.Router) {
@@ -17,7 +14,7 @@ func SetupDiscordPlaysProjects(dpHttp *DiscordPlaysHttp, router *mux.Router) {
 			rw.Header().Set("Location", fmt.Sprintf("%s://%s/bots/%s", dpHttp.Protocol, dpHttp.Domain.RootDomain, *b.Code))
 			rw.WriteHeader(http.StatusTemporaryRedirect)
 		} else {
-			router.NotFoundHandler.ServeHTTP(rw, req)
+			dpHttp.notFound(rw, req)
 		}
 	})
 	redirectToProjectAddress(dpHttp, router, "/invite", func(item *structure.ProjectItem) string {
@@ -31,12 +28,11 @@ func SetupDiscordPlaysProjects(dpHttp *DiscordPlaysHttp, router *mux.Router) {
 	})
 	imageForProjectAddress(dpHttp, router, "logo")
 	imageForProjectAddress(dpHttp, router, "banner")
-	router.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", http.FileServer(nfHttp.New(http.FS(res.GetAssetsFilesystem())))))
+	router.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", dpHttp.assets))
 }
 
 func getProjectItem(dpHttp *DiscordPlaysHttp, req *http.Request) (*structure.ProjectItem, bool) {
-	a := getFirstPartOfHost(req.Host)
-	return getProjectItemFromName(dpHttp, a)
+	return dpHttp.projectForHost(req.Host)
 }
 
 func getProjectItemFromName(dpHttp *DiscordPlaysHttp, name string) (*structure.ProjectItem, bool) {
@@ -46,12 +42,10 @@ func getProjectItemFromName(dpHttp *DiscordPlaysHttp, name string) (*structure.P
 	return b, ok
 }
 
-func getFirstPartOfHost(a string) string {
-	s := strings.Split(a, ".")
-	if len(s) >= 3 {
-		return s[len(s)-3]
-	}
-	return ""
+func getProjectList(dpHttp *DiscordPlaysHttp) []*structure.ProjectItem {
+	dpHttp.rwSync.RLock()
+	defer dpHttp.rwSync.RUnlock()
+	return dpHttp.projectData
 }
 
 func redirectToProjectAddress(dpHttp *DiscordPlaysHttp, router *mux.Router, prefix string, cb func(*structure.ProjectItem) string) {
@@ -60,7 +54,7 @@ func redirectToProjectAddress(dpHttp *DiscordPlaysHttp, router *mux.Router, pref
 			rw.Header().Set("Location", cb(item))
 			rw.WriteHeader(http.StatusTemporaryRedirect)
 		}, func() {
-			router.NotFoundHandler.ServeHTTP(rw, req)
+			dpHttp.notFound(rw, req)
 		})
 	})
 }
@@ -68,15 +62,20 @@ func redirectToProjectAddress(dpHttp *DiscordPlaysHttp, router *mux.Router, pref
 func imageForProjectAddress(dpHttp *DiscordPlaysHttp, router *mux.Router, name string) {
 	router.HandleFunc("/assets/"+name+".png", func(rw http.ResponseWriter, req *http.Request) {
 		useProjectItem(dpHttp, req, func(item *structure.ProjectItem) {
-			rw.Header().Set("Content-Type", "image/png")
-			f, err := res.GetAssetsFilesystem().Open(fmt.Sprintf("projects/%s/%s.png", *item.Code, name))
+			size, ok := parseImageSize(req.URL.Query().Get("size"))
+			if !ok {
+				dpHttp.writeError(rw, req, http.StatusBadRequest)
+				return
+			}
+			img, err := dpHttp.projectImages.get(*item.Code, name, size)
 			if err != nil {
-				rw.WriteHeader(500)
-			} else {
-				_, _ = io.Copy(rw, f)
+				log.Printf("[Http::Projects] Failed to load %s for '%s': %s\n", name, *item.Code, err)
+				dpHttp.writeError(rw, req, http.StatusInternalServerError)
+				return
 			}
+			serveProjectImage(rw, req, img)
 		}, func() {
-			router.NotFoundHandler.ServeHTTP(rw, req)
+			dpHttp.notFound(rw, req)
 		})
 	})
 }
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/discord-plays/website/structure"
+	"gorm.io/gorm"
+	"log"
+	"os"
+)
+
+const publicIdKeyName = "public-id"
+
+// publicIds turns Discord IDs into the IDs shown to projects and bots, they
+// are keyed so nobody can work back to the Discord account
+type publicIds struct {
+	key []byte
+	// legacy also hands out the old unkeyed IDs while projects and bots move
+	// over to the new ones
+	legacy bool
+}
+
+func newPublicIds(db *gorm.DB) (*publicIds, error) {
+	p := &publicIds{legacy: os.Getenv("LEGACY_PUBLIC_IDS") == "true"}
+	if k := os.Getenv("PUBLIC_ID_KEY"); k != "" {
+		key, err := hex.DecodeString(k)
+		if err != nil || len(key) < 32 {
+			return nil, errors.New("PUBLIC_ID_KEY must be at least 32 bytes of hex")
+		}
+		p.key = key
+		return p, nil
+	}
+
+	// Without a configured key one is made once and kept in the database,
+	// changing it would change every public ID
+	secret := &structure.ServerSecret{}
+	err := db.First(secret, "name = ?", publicIdKeyName).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		secret.Name = publicIdKeyName
+		secret.Value = make([]byte, 32)
+		if _, err := rand.Read(secret.Value); err != nil {
+			return nil, err
+		}
+		err = db.Create(secret).Error
+		log.Printf("[Http::PublicIds] Generated a new public ID key\n")
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.key = secret.Value
+	return p, nil
+}
+
+// Id is the public ID for a Discord user
+func (p *publicIds) Id(discordId string) string {
+	h := hmac.New(sha256.New, p.key)
+	h.Write([]byte(discordId))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16])
+}
+
+// LegacyId is the old unkeyed ID, it is only handed out when LEGACY_PUBLIC_IDS
+// is turned on
+func (p *publicIds) LegacyId(discordId string) string {
+	if !p.legacy {
+		return ""
+	}
+	return legacyPublicId(discordId)
+}
+
+func legacyPublicId(discordId string) string {
+	hash := md5.Sum([]byte(discordId))
+	return hex.EncodeToString(hash[:])
+}
+
+// migratePublicIds moves stored users over to the current public IDs, the old
+// IDs are never stored and are only worked out while LEGACY_PUBLIC_IDS is on
+func (dpHttp *DiscordPlaysHttp) migratePublicIds() {
+	var users []*structure.User
+	dpHttp.db.Find(&users)
+	moved := 0
+	for _, u := range users {
+		id := dpHttp.publicIds.Id(u.DiscordId)
+		if u.PublicId == id {
+			continue
+		}
+		err := dpHttp.db.Model(u).Update("public_id", id).Error
+		if err != nil {
+			log.Printf("[Http::PublicIds] Failed to migrate user %d: %s\n", u.ID, err)
+			continue
+		}
+		moved++
+	}
+	if moved > 0 {
+		log.Printf("[Http::PublicIds] Migrated %d users to new public IDs\n", moved)
+	}
+}
@@ -0,0 +1,311 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/discord-plays/website/structure"
+	"github.com/gorilla/mux"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitHistory is how long throttled clients stay on the admin page
+const rateLimitHistory = time.Hour
+
+func SetupDiscordPlaysRateLimits(dpHttp *DiscordPlaysHttp, adminRouter *mux.Router) {
+	adminRouter.HandleFunc("/rate-limits", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		dpHttp.generatePage(rw, req, dpUser, dpHttp.newPageMeta(req, "Rate Limits - Discord Plays Admin", ""), "admin-rate-limits.go.html", struct {
+			Groups    []rateLimitGroup
+			Throttled []rateLimitThrottle
+		}{
+			Groups:    dpHttp.rateLimits.Groups(),
+			Throttled: dpHttp.rateLimits.Throttled(),
+		})
+	})).Methods(http.MethodGet)
+}
+
+// rateLimitGroup is a token bucket quota shared by a group of routes, each
+// client gets Burst requests which refill evenly over Per
+type rateLimitGroup struct {
+	Name  string
+	Burst int
+	Per   time.Duration
+	// ByApiKey counts bots by their API key instead of their IP
+	ByApiKey bool
+}
+
+// defaultRateLimitGroups can be changed with RATE_LIMIT_<NAME>=<burst>/<per>,
+// for example RATE_LIMIT_LOGIN=20/1m
+var defaultRateLimitGroups = []rateLimitGroup{
+	{Name: "login", Burst: 20, Per: time.Minute},
+	{Name: "check", Burst: 120, Per: time.Minute},
+	{Name: "oauth", Burst: 60, Per: time.Minute},
+	{Name: "api", Burst: 300, Per: time.Minute, ByApiKey: true},
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimitThrottle is a client which has been sent a 429 recently
+type rateLimitThrottle struct {
+	Group    string
+	Client   string
+	Rejected int
+	First    time.Time
+	Last     time.Time
+}
+
+type rateLimiter struct {
+	mutex     *sync.Mutex
+	groups    map[string]*rateLimitGroup
+	buckets   map[string]*rateBucket
+	throttled map[string]*rateLimitThrottle
+}
+
+func newRateLimiter() *rateLimiter {
+	r := &rateLimiter{
+		mutex:     &sync.Mutex{},
+		groups:    make(map[string]*rateLimitGroup),
+		buckets:   make(map[string]*rateBucket),
+		throttled: make(map[string]*rateLimitThrottle),
+	}
+	for _, g := range defaultRateLimitGroups {
+		g := g
+		envName := "RATE_LIMIT_" + strings.ToUpper(g.Name)
+		if v := os.Getenv(envName); v != "" {
+			burst, per, err := parseRateLimit(v)
+			if err != nil {
+				log.Fatalf("[Http::RateLimit] Invalid %s: %s\n", envName, err)
+			}
+			g.Burst, g.Per = burst, per
+		}
+		r.groups[g.Name] = &g
+	}
+	return r
+}
+
+func parseRateLimit(v string) (int, time.Duration, error) {
+	b, p, ok := strings.Cut(v, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected <burst>/<per> but got %q", v)
+	}
+	burst, err := strconv.Atoi(b)
+	if err != nil || burst < 1 {
+		return 0, 0, fmt.Errorf("invalid burst %q", b)
+	}
+	per, err := time.ParseDuration(p)
+	if err != nil || per <= 0 {
+		return 0, 0, fmt.Errorf("invalid period %q", p)
+	}
+	return burst, per, nil
+}
+
+// allow takes a token from the client's bucket, if it is empty the client has
+// to wait for retryAfter
+func (r *rateLimiter) allow(g *rateLimitGroup, client string, now time.Time) (bool, time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rate := float64(g.Burst) / g.Per.Seconds()
+	key := g.Name + " " + client
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &rateBucket{tokens: float64(g.Burst), last: now}
+		r.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(g.Burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	t, ok := r.throttled[key]
+	if !ok {
+		t = &rateLimitThrottle{Group: g.Name, Client: client, First: now}
+		r.throttled[key] = t
+	}
+	t.Rejected++
+	t.Last = now
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// prune drops buckets which have refilled and old throttle records
+func (r *rateLimiter) prune(now time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for key, b := range r.buckets {
+		g := r.groups[strings.SplitN(key, " ", 2)[0]]
+		if now.Sub(b.last) >= g.Per {
+			delete(r.buckets, key)
+		}
+	}
+	for key, t := range r.throttled {
+		if now.Sub(t.Last) >= rateLimitHistory {
+			delete(r.throttled, key)
+		}
+	}
+}
+
+// pruneLoop clears out old buckets until the stop channel is closed
+func (r *rateLimiter) pruneLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			r.prune(now)
+		}
+	}
+}
+
+// Groups lists the quotas by name
+func (r *rateLimiter) Groups() []rateLimitGroup {
+	groups := make([]rateLimitGroup, 0, len(r.groups))
+	for _, g := range r.groups {
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// Throttled lists the clients sent a 429 recently, the most recent first
+func (r *rateLimiter) Throttled() []rateLimitThrottle {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	throttled := make([]rateLimitThrottle, 0, len(r.throttled))
+	for _, t := range r.throttled {
+		throttled = append(throttled, *t)
+	}
+	sort.Slice(throttled, func(i, j int) bool {
+		return throttled[i].Last.After(throttled[j].Last)
+	})
+	return throttled
+}
+
+// rateLimit puts the route into a rate limit group
+func (dpHttp *DiscordPlaysHttp) rateLimit(group string, route *mux.Route) *mux.Route {
+	g, ok := dpHttp.rateLimits.groups[group]
+	if !ok {
+		log.Fatalf("[Http::RateLimit] Unknown rate limit group: %s\n", group)
+	}
+	dpHttp.rateLimitRoutes[route] = g
+	return route
+}
+
+// rateLimitMiddleware sends a 429 once a client runs out of requests for the
+// route's group
+func (dpHttp *DiscordPlaysHttp) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		g, ok := dpHttp.rateLimitRoutes[mux.CurrentRoute(req)]
+		if !ok {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		client := dpHttp.clientIp(req)
+		if g.ByApiKey {
+			// Only working keys get their own bucket, made up keys count
+			// against the IP
+			if key, ok := dpHttp.getApiKey(apiKeyFromRequest(req)); ok {
+				client = rateLimitKeyName(key)
+				req = req.WithContext(context.WithValue(req.Context(), apiKeyContextKey{}, key))
+			}
+		}
+		if ok, retryAfter := dpHttp.rateLimits.allow(g, client, time.Now()); !ok {
+			rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			dpHttp.writeError(rw, req, http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// apiKeyFromRequest reads the key bots send as "Authorization: Bot <key>"
+func apiKeyFromRequest(req *http.Request) string {
+	key, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bot ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(key)
+}
+
+// rateLimitKeyName is how an API key is shown on the admin page without
+// giving the key away
+func rateLimitKeyName(key *structure.ApiKey) string {
+	return fmt.Sprintf("key %d (%s)", key.ID, key.Name)
+}
+
+// parseTrustedProxies reads TRUSTED_PROXIES, a comma separated list of IPs or
+// CIDR ranges whose X-Forwarded-For headers are believed
+func parseTrustedProxies(v string) []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, p := range strings.Split(v, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(p)
+		if err != nil {
+			log.Fatalf("[Http::RateLimit] Invalid trusted proxy: %s\n", p)
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies
+}
+
+func (dpHttp *DiscordPlaysHttp) isTrustedProxy(ip net.IP) bool {
+	for _, p := range dpHttp.trustedProxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIp is the address of the client, behind trusted proxies it is the
+// last address in X-Forwarded-For which was not added by one of them
+func (dpHttp *DiscordPlaysHttp) clientIp(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !dpHttp.isTrustedProxy(ip) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		f := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if f == nil {
+			break
+		}
+		ip = f
+		if !dpHttp.isTrustedProxy(f) {
+			break
+		}
+	}
+	return ip.String()
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"github.com/discord-plays/website/structure"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		v         string
+		wantBurst int
+		wantPer   time.Duration
+		wantErr   bool
+	}{
+		{"per minute", "20/1m", 20, time.Minute, false},
+		{"per second", "5/1s", 5, time.Second, false},
+		{"no period", "20", 0, 0, true},
+		{"zero burst", "0/1m", 0, 0, true},
+		{"negative period", "20/-1m", 0, 0, true},
+		{"unit missing", "20/60", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			burst, per, err := parseRateLimit(tt.v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRateLimit(%q) error = %v, wantErr %v", tt.v, err, tt.wantErr)
+			}
+			if burst != tt.wantBurst || per != tt.wantPer {
+				t.Errorf("parseRateLimit(%q) = %d, %s, want %d, %s", tt.v, burst, per, tt.wantBurst, tt.wantPer)
+			}
+		})
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	r := newRateLimiter()
+	g := &rateLimitGroup{Name: "test", Burst: 2, Per: 2 * time.Second}
+	r.groups[g.Name] = g
+	now := time.Now()
+	tests := []struct {
+		name   string
+		client string
+		after  time.Duration
+		want   bool
+	}{
+		{"first request", "a", 0, true},
+		{"second request", "a", 0, true},
+		{"out of requests", "a", 0, false},
+		{"another client", "b", 0, true},
+		{"before a request has refilled", "a", 500 * time.Millisecond, false},
+		{"after a request has refilled", "a", time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := r.allow(g, tt.client, now.Add(tt.after)); got != tt.want {
+				t.Errorf("allow(%q) = %v, want %v", tt.client, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRateLimitMiddlewareBuckets sends two requests with a burst of one, the
+// second is only allowed if it is counted in a different bucket
+func TestRateLimitMiddlewareBuckets(t *testing.T) {
+	t.Setenv("RATE_LIMIT_API", "1/1m")
+	keyA, keyB, disabled := apiKeyPrefix+"a", apiKeyPrefix+"b", apiKeyPrefix+"disabled"
+	tests := []struct {
+		name   string
+		first  [2]string
+		second [2]string
+		want   int
+	}{
+		{"same key from another IP", [2]string{"192.0.2.1", keyA}, [2]string{"192.0.2.2", keyA}, http.StatusTooManyRequests},
+		{"another key from the same IP", [2]string{"192.0.2.1", keyA}, [2]string{"192.0.2.1", keyB}, http.StatusNoContent},
+		{"key then no key from the same IP", [2]string{"192.0.2.1", keyA}, [2]string{"192.0.2.1", ""}, http.StatusNoContent},
+		{"made up keys from the same IP", [2]string{"192.0.2.1", apiKeyPrefix + "fake1"}, [2]string{"192.0.2.1", apiKeyPrefix + "fake2"}, http.StatusTooManyRequests},
+		{"made up key then no key", [2]string{"192.0.2.1", apiKeyPrefix + "fake"}, [2]string{"192.0.2.1", ""}, http.StatusTooManyRequests},
+		{"disabled key from the same IP", [2]string{"192.0.2.1", ""}, [2]string{"192.0.2.1", disabled}, http.StatusTooManyRequests},
+		{"no key from another IP", [2]string{"192.0.2.1", ""}, [2]string{"192.0.2.2", ""}, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dpHttp := newRedirectTestHttp()
+			dpHttp.db = newTestDb(t, &structure.ApiKey{})
+			dpHttp.rateLimits = newRateLimiter()
+			dpHttp.db.Create(&structure.ApiKey{Name: "a", KeyHash: hashOAuthSecret(keyA)})
+			dpHttp.db.Create(&structure.ApiKey{Name: "b", KeyHash: hashOAuthSecret(keyB)})
+			dpHttp.db.Create(&structure.ApiKey{Name: "disabled", KeyHash: hashOAuthSecret(disabled), Disabled: true})
+
+			router := mux.NewRouter()
+			dpHttp.apiRoute(dpHttp.rateLimit("api", router.HandleFunc("/api/bot/users", func(rw http.ResponseWriter, req *http.Request) {
+				// Working keys are passed on so they aren't looked up again
+				_, hasKey := req.Context().Value(apiKeyContextKey{}).(*structure.ApiKey)
+				if key := apiKeyFromRequest(req); hasKey != (key == keyA || key == keyB) {
+					t.Errorf("API key %q in the context = %v", key, hasKey)
+				}
+				rw.WriteHeader(http.StatusNoContent)
+			})))
+			router.Use(dpHttp.rateLimitMiddleware)
+			send := func(r [2]string) int {
+				req := httptest.NewRequest(http.MethodGet, "https://id.dp.test/api/bot/users", nil)
+				req.RemoteAddr = r[0] + ":1234"
+				if r[1] != "" {
+					req.Header.Set("Authorization", "Bot "+r[1])
+				}
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+				return rec.Code
+			}
+
+			if got := send(tt.first); got != http.StatusNoContent {
+				t.Fatalf("first request = %d, want %d", got, http.StatusNoContent)
+			}
+			if got := send(tt.second); got != tt.want {
+				t.Errorf("second request = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"github.com/discord-plays/website/structure"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// splitProjectHosts reads a comma separated list of aliases or domains from a
+// project, hosts are compared in lower case
+func splitProjectHosts(s *string) []string {
+	if s == nil {
+		return nil
+	}
+	var hosts []string
+	for _, h := range strings.Split(*s, ",") {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// projectForHost finds the project served on a host, either a single label
+// under the project domain matching its code or an alias, or one of its
+// custom domains
+func (dpHttp *DiscordPlaysHttp) projectForHost(host string) (*structure.ProjectItem, bool) {
+	host = strings.ToLower(host)
+	dpHttp.rwSync.RLock()
+	defer dpHttp.rwSync.RUnlock()
+	if p, ok := dpHttp.projectDomains[host]; ok {
+		return p, true
+	}
+
+	// The dot keeps look-alike hosts such as evil-dp.test out
+	label, ok := strings.CutSuffix(host, "."+strings.ToLower(strings.TrimPrefix(dpHttp.Domain.ProjectDomain, ".")))
+	if !ok || label == "" || strings.Contains(label, ".") {
+		return nil, false
+	}
+	if p, ok := dpHttp.projectItems[label]; ok {
+		return p, true
+	}
+	p, ok := dpHttp.projectAliases[label]
+	return p, ok
+}
+
+// isAllowedRedirectHost is the allowlist for hosts users are sent back to after
+// logging in or out and for origins the login frames post messages to
+func (dpHttp *DiscordPlaysHttp) isAllowedRedirectHost(host string) bool {
+	if host == "" {
+		return false
+	}
+	switch strings.ToLower(host) {
+	case strings.ToLower(dpHttp.Domain.RootDomain), strings.ToLower(dpHttp.Domain.AdminDomain):
+		return true
+	}
+	_, ok := dpHttp.projectForHost(host)
+	return ok
+}
+
+// allowedRedirectHost falls back to the root domain for hosts which are not
+// allowed
+func (dpHttp *DiscordPlaysHttp) allowedRedirectHost(host string) string {
+	if dpHttp.isAllowedRedirectHost(host) {
+		return host
+	}
+	return dpHttp.Domain.RootDomain
+}
+
+// isAllowedRedirectUrl checks an absolute url points at an allowed host
+func (dpHttp *DiscordPlaysHttp) isAllowedRedirectUrl(u *url.URL) bool {
+	return u.Scheme == dpHttp.Protocol && u.Opaque == "" && u.User == nil && dpHttp.isAllowedRedirectHost(u.Host)
+}
+
+// safeReturnUrl only allows sending the user back to a Discord Plays page
+func (dpHttp *DiscordPlaysHttp) safeReturnUrl(returnUrl string) string {
+	u, err := url.Parse(returnUrl)
+	if err != nil || !dpHttp.isAllowedRedirectUrl(u) {
+		return dpHttp.rootUrl()
+	}
+	return u.String()
+}
+
+// requestOrigin is the Origin header, or the Referer for browsers which leave
+// it out. Browsers send "null" from sandboxed frames and pages which turn the
+// referrer off, that says nothing about the site so it counts as missing.
+func requestOrigin(req *http.Request) string {
+	if origin := req.Header.Get("Origin"); origin != "" && origin != "null" {
+		return origin
+	}
+	return req.Referer()
+}
+
+// isSameSiteRequest checks the Origin header, or the Referer for browsers
+// which leave it out, came from one of the Discord Plays domains
+func (dpHttp *DiscordPlaysHttp) isSameSiteRequest(req *http.Request) bool {
+	u, err := url.Parse(requestOrigin(req))
+	if err != nil {
+		return false
+	}
+	// Pages on the id domain post forms to themselves
+	if u.Scheme == dpHttp.Protocol && u.User == nil && strings.EqualFold(u.Host, dpHttp.Domain.IdDomain) {
+		return true
+	}
+	return dpHttp.isAllowedRedirectUrl(u)
+}
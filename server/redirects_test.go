@@ -0,0 +1,136 @@
+package server
+
+import (
+	"github.com/discord-plays/website/structure"
+	"net/http"
+	"testing"
+)
+
+func newRedirectTestHttp() *DiscordPlaysHttp {
+	dpHttp := New(nil)
+	dpHttp.Protocol = "https"
+	dpHttp.Domain = &structure.Domains{
+		RootDomain:    "dp.test",
+		IdDomain:      "id.dp.test",
+		AdminDomain:   "admin.dp.test",
+		ProjectDomain: ".dp.test",
+	}
+	minesweeper := structure.NewProjectItem("minesweeper", "Minesweeper", "", "", "", "", "", "")
+	aliases, domains := "mines, sweeper", "Minesweeper.example"
+	minesweeper.Aliases = &aliases
+	minesweeper.Domains = &domains
+	dpHttp.projectItems["minesweeper"] = minesweeper
+	for _, a := range splitProjectHosts(minesweeper.Aliases) {
+		dpHttp.projectAliases[a] = minesweeper
+	}
+	for _, d := range splitProjectHosts(minesweeper.Domains) {
+		dpHttp.projectDomains[d] = minesweeper
+	}
+	return dpHttp
+}
+
+func TestIsAllowedRedirectHost(t *testing.T) {
+	dpHttp := newRedirectTestHttp()
+	tests := []struct {
+		name string
+		host string
+		want bool
+	}{
+		{"root domain", "dp.test", true},
+		{"admin domain", "admin.dp.test", true},
+		{"root domain in upper case", "DP.TEST", true},
+		{"known project", "minesweeper.dp.test", true},
+		{"project alias", "mines.dp.test", true},
+		{"second project alias", "sweeper.dp.test", true},
+		{"custom domain", "minesweeper.example", true},
+		{"custom domain in upper case", "MINESWEEPER.example", true},
+		{"empty host", "", false},
+		{"id domain", "id.dp.test", false},
+		{"unknown project", "unknown.dp.test", false},
+		{"look-alike without a dot", "evil-dp.test", false},
+		{"look-alike sharing the suffix", "evildp.test", false},
+		{"project domain without a label", ".dp.test", false},
+		{"nested subdomain of a project", "a.minesweeper.dp.test", false},
+		{"project domain as a prefix", "minesweeper.dp.test.evil.test", false},
+		{"project code on another domain", "minesweeper.evil.test", false},
+		{"subdomain of a custom domain", "a.minesweeper.example", false},
+		{"alias as a custom domain", "mines", false},
+		{"different port", "dp.test:8080", false},
+		{"trailing dot", "dp.test.", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dpHttp.isAllowedRedirectHost(tt.host); got != tt.want {
+				t.Errorf("isAllowedRedirectHost(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafeReturnUrl(t *testing.T) {
+	dpHttp := newRedirectTestHttp()
+	tests := []struct {
+		name      string
+		returnUrl string
+		want      string
+	}{
+		{"root page", "https://dp.test/bots", "https://dp.test/bots"},
+		{"project page with query", "https://minesweeper.dp.test/?a=b", "https://minesweeper.dp.test/?a=b"},
+		{"custom domain", "https://minesweeper.example/play", "https://minesweeper.example/play"},
+		{"empty", "", "https://dp.test"},
+		{"relative path", "/bots", "https://dp.test"},
+		{"protocol relative", "//evil.test/", "https://dp.test"},
+		{"wrong protocol", "http://dp.test/", "https://dp.test"},
+		{"javascript", "javascript:alert(1)", "https://dp.test"},
+		{"opaque url", "https:dp.test", "https://dp.test"},
+		{"user info", "https://dp.test@evil.test/", "https://dp.test"},
+		{"user info on own host", "https://evil@dp.test/", "https://dp.test"},
+		{"look-alike host", "https://evil-dp.test/", "https://dp.test"},
+		{"id domain", "https://id.dp.test/account", "https://dp.test"},
+		{"backslash host", "https://evil.test\\dp.test/", "https://dp.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dpHttp.safeReturnUrl(tt.returnUrl); got != tt.want {
+				t.Errorf("safeReturnUrl(%q) = %q, want %q", tt.returnUrl, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSameSiteRequest(t *testing.T) {
+	dpHttp := newRedirectTestHttp()
+	tests := []struct {
+		name    string
+		origin  string
+		referer string
+		want    bool
+	}{
+		{"root origin", "https://dp.test", "", true},
+		{"id origin", "https://id.dp.test", "", true},
+		{"project origin", "https://mines.dp.test", "", true},
+		{"custom domain origin", "https://minesweeper.example", "", true},
+		{"referer without origin", "", "https://admin.dp.test/news", true},
+		{"look-alike origin", "https://evil-dp.test", "", false},
+		{"wrong protocol", "http://dp.test", "", false},
+		{"null origin without referer", "null", "", false},
+		{"null origin falls back to referer", "null", "https://dp.test/bots", true},
+		{"null origin with look-alike referer", "null", "https://evil-dp.test/", false},
+		{"origin wins over referer", "https://evil.test", "https://dp.test/", false},
+		{"neither", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, "https://id.dp.test/", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				req.Header.Set("Referer", tt.referer)
+			}
+			if got := dpHttp.isSameSiteRequest(req); got != tt.want {
+				t.Errorf("isSameSiteRequest(origin %q, referer %q) = %v, want %v", tt.origin, tt.referer, got, tt.want)
+			}
+		})
+	}
+}
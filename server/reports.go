@@ -0,0 +1,194 @@
+package server
+
+import (
+	"fmt"
+	"github.com/discord-plays/website/structure"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	screenshotDir                = ".data/screenshots"
+	maxScreenshotSize            = 5 << 20
+	maxReportTitleLength         = 100
+	maxReportDescriptionLength   = 4000
+	maxReportRequestOverheadSize = 1 << 20
+)
+
+func SetupDiscordPlaysReports(dpHttp *DiscordPlaysHttp, rootRouter *mux.Router, adminRouter *mux.Router) {
+	rootRouter.HandleFunc("/bots/{botName}/report", func(rw http.ResponseWriter, req *http.Request) {
+		_, dpUser, ok := dpHttp.dpSess.CheckLogin(req)
+		if !ok {
+			dpHttp.writeError(rw, req, http.StatusForbidden)
+			return
+		}
+		botName := mux.Vars(req)["botName"]
+		project, ok := getProjectItemFromName(dpHttp, botName)
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+
+		req.Body = http.MaxBytesReader(rw, req.Body, maxScreenshotSize+maxReportRequestOverheadSize)
+		if err := req.ParseMultipartForm(maxScreenshotSize); err != nil {
+			http.Redirect(rw, req, fmt.Sprintf("/bots/%s?report=too-large#report", botName), http.StatusSeeOther)
+			return
+		}
+		title := strings.TrimSpace(req.PostFormValue("title"))
+		description := strings.TrimSpace(req.PostFormValue("description"))
+		if title == "" || description == "" {
+			http.Redirect(rw, req, fmt.Sprintf("/bots/%s?report=missing#report", botName), http.StatusSeeOther)
+			return
+		}
+		if utf8.RuneCountInString(title) > maxReportTitleLength || utf8.RuneCountInString(description) > maxReportDescriptionLength {
+			http.Redirect(rw, req, fmt.Sprintf("/bots/%s?report=length#report", botName), http.StatusSeeOther)
+			return
+		}
+
+		report := &structure.BugReport{
+			ProjectItemID: project.ID,
+			ReporterId:    dpUser.Id,
+			ReporterName:  dpUser.DisplayName(),
+			Title:         title,
+			Description:   description,
+			Status:        structure.BugReportOpen,
+		}
+
+		if f, _, err := req.FormFile("screenshot"); err == nil {
+			name, err := saveUploadedImage(f, screenshotDir)
+			_ = f.Close()
+			if err != nil {
+				log.Printf("[Http::Reports] Failed to save screenshot: %s\n", err)
+				http.Redirect(rw, req, fmt.Sprintf("/bots/%s?report=screenshot#report", botName), http.StatusSeeOther)
+				return
+			}
+			report.Screenshot = name
+		}
+
+		if err := dpHttp.db.Create(report).Error; err != nil {
+			log.Printf("[Http::Reports] Failed to save report: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(rw, req, "/reports", http.StatusSeeOther)
+	}).Methods(http.MethodPost)
+	rootRouter.HandleFunc("/reports", func(rw http.ResponseWriter, req *http.Request) {
+		_, dpUser, ok := dpHttp.dpSess.CheckLogin(req)
+		var reports []*structure.BugReport
+		if ok {
+			if err := dpHttp.db.Preload("ProjectItem").Where("reporter_id = ?", dpUser.Id).Order("created_at desc").Find(&reports).Error; err != nil {
+				log.Printf("[Http::Reports] Failed to load reports: %s\n", err)
+				dpHttp.writeError(rw, req, http.StatusInternalServerError)
+				return
+			}
+		}
+		meta := dpHttp.newPageMeta(req, "My Reports", "")
+		meta.NoIndex = true
+		dpHttp.generatePage(rw, req, dpUser, meta, "reports.go.html", struct {
+			Reports  []*structure.BugReport
+			SignedIn bool
+		}{
+			Reports:  reports,
+			SignedIn: ok,
+		})
+	}).Methods(http.MethodGet)
+	rootRouter.HandleFunc("/reports/{id:[0-9]+}/screenshot", func(rw http.ResponseWriter, req *http.Request) {
+		_, dpUser, ok := dpHttp.dpSess.CheckLogin(req)
+		report, found := getBugReportFromVars(dpHttp, req)
+		if !ok || !found || (report.ReporterId != dpUser.Id && !dpHttp.isAdminUser(dpUser.Id)) {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		dpHttp.serveScreenshot(rw, req, report)
+	}).Methods(http.MethodGet)
+
+	adminRouter.HandleFunc("/reports", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		status := req.URL.Query().Get("status")
+		q := dpHttp.db.Preload("ProjectItem").Order("created_at desc")
+		if structure.IsBugReportStatus(status) {
+			q = q.Where("status = ?", status)
+		} else {
+			status = ""
+		}
+		var reports []*structure.BugReport
+		if err := q.Find(&reports).Error; err != nil {
+			log.Printf("[Http::Reports] Failed to load reports: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		dpHttp.generatePage(rw, req, dpUser, dpHttp.newPageMeta(req, "Discord Plays Admin - Reports", ""), "admin-reports.go.html", struct {
+			Reports  []*structure.BugReport
+			Statuses []string
+			Status   string
+			Admins   []string
+			Me       string
+		}{
+			Reports:  reports,
+			Statuses: structure.BugReportStatuses,
+			Status:   status,
+			Admins:   dpHttp.dpAdmins,
+			Me:       dpUser.Id,
+		})
+	})).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/reports/{id:[0-9]+}", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		report, ok := getBugReportFromVars(dpHttp, req)
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		status := req.PostFormValue("status")
+		if !structure.IsBugReportStatus(status) {
+			dpHttp.writeError(rw, req, http.StatusBadRequest)
+			return
+		}
+		assignee := req.PostFormValue("assignee")
+		if assignee != "" && !dpHttp.isAdminUser(assignee) {
+			dpHttp.writeError(rw, req, http.StatusBadRequest)
+			return
+		}
+		report.Status = status
+		report.Assignee = assignee
+		report.Notes = strings.TrimSpace(req.PostFormValue("notes"))
+		if err := dpHttp.db.Model(report).Select("Status", "Assignee", "Notes").Updates(report).Error; err != nil {
+			log.Printf("[Http::Reports] Failed to update report: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(rw, req, fmt.Sprintf("/reports#report-%d", report.ID), http.StatusSeeOther)
+	})).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/reports/{id:[0-9]+}/screenshot", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		report, ok := getBugReportFromVars(dpHttp, req)
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		dpHttp.serveScreenshot(rw, req, report)
+	})).Methods(http.MethodGet)
+}
+
+func getBugReportFromVars(dpHttp *DiscordPlaysHttp, req *http.Request) (*structure.BugReport, bool) {
+	id, err := strconv.ParseUint(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	report := &structure.BugReport{}
+	if dpHttp.db.Limit(1).Find(report, id).RowsAffected == 0 {
+		return nil, false
+	}
+	return report, true
+}
+
+func (dpHttp *DiscordPlaysHttp) serveScreenshot(rw http.ResponseWriter, req *http.Request, report *structure.BugReport) {
+	if report.Screenshot == "" {
+		dpHttp.notFound(rw, req)
+		return
+	}
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.Header().Set("Cache-Control", "private")
+	http.ServeFile(rw, req, filepath.Join(screenshotDir, filepath.Base(report.Screenshot)))
+}
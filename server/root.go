@@ -1,9 +1,7 @@
 package server
 
 import (
-	nfHttp "code.mrmelon54.com/melon/neutered-filesystem/http"
 	"fmt"
-	"github.com/discord-plays/website/res"
 	"github.com/discord-plays/website/structure"
 	"net/http"
 
@@ -15,7 +13,7 @@ func SetupDiscordPlaysRoot(dpHttp *DiscordPlaysHttp, router *mux.Router, linkDis
 		_, dpUser, _ := dpHttp.dpSess.CheckLogin(req)
 		dpHttp.rwSync.RLock()
 		defer dpHttp.rwSync.RUnlock()
-		dpHttp.generatePage(rw, dpUser, "Discord Plays", res.GetTemplateFileByName("index.go.html"), struct {
+		dpHttp.generatePage(rw, req, dpUser, dpHttp.newPageMeta(req, "Discord Plays", ""), "index.go.html", struct {
 			Projects      []*structure.ProjectItem
 			Protocol      string
 			ProjectDomain string
@@ -26,25 +24,28 @@ func SetupDiscordPlaysRoot(dpHttp *DiscordPlaysHttp, router *mux.Router, linkDis
 		})
 	})
 	router.HandleFunc("/bots/{botName}", func(rw http.ResponseWriter, req *http.Request) {
-		_, dpUser, _ := dpHttp.dpSess.CheckLogin(req)
+		_, dpUser, signedIn := dpHttp.dpSess.CheckLogin(req)
 		vars := mux.Vars(req)
 		botName := vars["botName"]
 		if b, ok := getProjectItemFromName(dpHttp, botName); ok {
-			dpHttp.generatePage(rw, dpUser, "Discord Plays "+*b.Name, res.GetTemplateFileByName("project.go.html"), struct {
-				Project    *structure.ProjectItem
-				ProjectUrl string
+			projectUrl := fmt.Sprintf("%s://%s%s", dpHttp.Protocol, *b.Code, dpHttp.Domain.ProjectDomain)
+			dpHttp.generatePage(rw, req, dpUser, dpHttp.newProjectPageMeta(req, b, projectUrl), "project.go.html", struct {
+				Project     *structure.ProjectItem
+				ProjectUrl  string
+				SignedIn    bool
+				ReportError string
+				News        []*structure.NewsPost
 			}{
-				Project:    b,
-				ProjectUrl: fmt.Sprintf("%s://%s%s", dpHttp.Protocol, *b.Code, dpHttp.Domain.ProjectDomain),
+				Project:     b,
+				ProjectUrl:  projectUrl,
+				SignedIn:    signedIn,
+				ReportError: req.URL.Query().Get("report"),
+				News:        dpHttp.loadProjectNews(b, 3),
 			})
 		} else {
-			router.NotFoundHandler.ServeHTTP(rw, req)
+			dpHttp.notFound(rw, req)
 		}
 	})
-	router.HandleFunc("/about", func(rw http.ResponseWriter, req *http.Request) {
-		_, dpUser, _ := dpHttp.dpSess.CheckLogin(req)
-		dpHttp.generatePage(rw, dpUser, "About", res.GetTemplateFileByName("about.go.html"), nil)
-	})
 	router.HandleFunc("/discord", func(rw http.ResponseWriter, req *http.Request) {
 		rw.Header().Set("Location", linkDiscord)
 		rw.WriteHeader(http.StatusTemporaryRedirect)
@@ -57,5 +58,5 @@ func SetupDiscordPlaysRoot(dpHttp *DiscordPlaysHttp, router *mux.Router, linkDis
 		rw.Header().Set("Location", linkGithub)
 		rw.WriteHeader(http.StatusTemporaryRedirect)
 	})
-	router.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", http.FileServer(nfHttp.New(http.FS(res.GetAssetsFilesystem())))))
+	router.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", dpHttp.assets))
 }
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/discord-plays/website/structure"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"time"
+)
+
+const defaultPageDescription = "Play games together on Discord with the Discord Plays bots, from Minesweeper to Caravan."
+
+func SetupDiscordPlaysSeo(dpHttp *DiscordPlaysHttp, rootRouter *mux.Router, idRouter *mux.Router, adminRouter *mux.Router) {
+	rootRouter.HandleFunc("/robots.txt", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = fmt.Fprintf(rw, "User-agent: *\nDisallow: /reports\nAllow: /\n\nSitemap: %s/sitemap.xml\n", dpHttp.rootUrl())
+	}).Methods(http.MethodGet)
+	disallowAll := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = rw.Write([]byte("User-agent: *\nDisallow: /\n"))
+	}
+	idRouter.HandleFunc("/robots.txt", disallowAll).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/robots.txt", disallowAll).Methods(http.MethodGet)
+
+	rootRouter.HandleFunc("/sitemap.xml", func(rw http.ResponseWriter, req *http.Request) {
+		root := dpHttp.rootUrl()
+		set := &sitemapUrlSet{}
+		set.add(root+"/", time.Time{})
+		set.add(root+"/about", time.Time{})
+		set.add(root+"/ideas", time.Time{})
+		set.add(root+"/news", time.Time{})
+		for _, p := range getProjectList(dpHttp) {
+			set.add(fmt.Sprintf("%s/bots/%s", root, *p.Code), p.UpdatedAt)
+		}
+		var posts []*structure.NewsPost
+		if err := publishedNews(dpHttp.db).Find(&posts).Error; err != nil {
+			log.Printf("[Http::Seo] Failed to load posts: %s\n", err)
+		}
+		for _, p := range posts {
+			set.add(fmt.Sprintf("%s/news/%s", root, p.Slug), p.UpdatedAt)
+		}
+
+		rw.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		_, _ = rw.Write([]byte(xml.Header))
+		enc := xml.NewEncoder(rw)
+		enc.Indent("", "  ")
+		if err := enc.Encode(set); err != nil {
+			log.Printf("[Http::Seo] Failed to write sitemap: %s\n", err)
+		}
+	}).Methods(http.MethodGet)
+}
+
+// newPageMeta creates the default metadata for a page, pages on the admin host
+// are never indexed
+func (dpHttp *DiscordPlaysHttp) newPageMeta(req *http.Request, title, description string) *structure.PageMeta {
+	if description == "" {
+		description = defaultPageDescription
+	}
+	return &structure.PageMeta{
+		Title:       title,
+		Description: description,
+		Canonical:   fmt.Sprintf("%s://%s%s", dpHttp.Protocol, req.Host, req.URL.EscapedPath()),
+		Image:       dpHttp.rootUrl() + "/assets/logo.png",
+		Type:        "website",
+		NoIndex:     req.Host == dpHttp.Domain.AdminDomain,
+	}
+}
+
+// newProjectPageMeta describes a bot page including the SoftwareApplication
+// structured data for search engines
+func (dpHttp *DiscordPlaysHttp) newProjectPageMeta(req *http.Request, project *structure.ProjectItem, projectUrl string) *structure.PageMeta {
+	description := *project.SubText
+	if *project.Description != "" {
+		description = *project.Description
+	}
+	meta := dpHttp.newPageMeta(req, "Discord Plays "+*project.Name, description)
+	meta.Canonical = fmt.Sprintf("%s/bots/%s", dpHttp.rootUrl(), *project.Code)
+	meta.Image = dpHttp.shareCardUrl(project)
+	meta.ImageWidth = shareCardWidth
+	meta.ImageHeight = shareCardHeight
+	meta.LargeImage = true
+
+	sameAs := make([]string, 0, 2)
+	if *project.Github != "" {
+		sameAs = append(sameAs, *project.Github)
+	}
+	if *project.Notion != "" {
+		sameAs = append(sameAs, *project.Notion)
+	}
+	meta.JsonLd = map[string]interface{}{
+		"@context":            "https://schema.org",
+		"@type":               "SoftwareApplication",
+		"name":                "Discord Plays " + *project.Name,
+		"description":         meta.Description,
+		"url":                 meta.Canonical,
+		"image":               projectUrl + "/assets/logo.png",
+		"applicationCategory": "GameApplication",
+		"operatingSystem":     "Discord",
+		"installUrl":          *project.Invite,
+		"sameAs":              sameAs,
+		"offers": map[string]string{
+			"@type":         "Offer",
+			"price":         "0",
+			"priceCurrency": "USD",
+		},
+	}
+	return meta
+}
+
+type sitemapUrlSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	Urls    []sitemapUrl `xml:"url"`
+}
+
+type sitemapUrl struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+func (s *sitemapUrlSet) add(loc string, lastMod time.Time) {
+	u := sitemapUrl{Loc: loc}
+	if !lastMod.IsZero() {
+		u.LastMod = lastMod.UTC().Format("2006-01-02")
+	}
+	s.Urls = append(s.Urls, u)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	sessionHashKeySize       = 64
+	sessionEncryptionKeySize = 32
+)
+
+// sessionKeyPair signs and encrypts session cookies, the encryption key is
+// empty for the old SESSION_ENCRYPTION key which only signed them
+type sessionKeyPair struct {
+	hashKey       []byte
+	encryptionKey []byte
+}
+
+// sessionKeysFromEnv reads SESSION_KEYS, a comma separated list of
+// "hash:encryption" hex key pairs with the newest first. New cookies use the
+// first pair and the rest are only for reading older cookies. SESSION_ENCRYPTION
+// is kept last so logins from before the keys were set up keep working, it
+// can't encrypt so it is never used for new cookies.
+func sessionKeysFromEnv() ([]sessionKeyPair, error) {
+	keys, err := parseSessionKeys(os.Getenv("SESSION_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("SESSION_KEYS is empty, run the keygen command to make one")
+	}
+	if legacy := os.Getenv("SESSION_ENCRYPTION"); legacy != "" {
+		keys = append(keys, sessionKeyPair{hashKey: []byte(legacy)})
+	}
+	return keys, nil
+}
+
+func parseSessionKeys(s string) ([]sessionKeyPair, error) {
+	var keys []sessionKeyPair
+	for i, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		hashHex, encHex, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("SESSION_KEYS pair %d is not hash:encryption", i+1)
+		}
+		hashKey, err := hex.DecodeString(hashHex)
+		if err != nil || len(hashKey) < 32 {
+			return nil, fmt.Errorf("SESSION_KEYS pair %d needs a hash key of at least 32 bytes of hex", i+1)
+		}
+		encKey, err := hex.DecodeString(encHex)
+		if err != nil || len(encKey) != sessionEncryptionKeySize {
+			return nil, fmt.Errorf("SESSION_KEYS pair %d needs an encryption key of %d bytes of hex", i+1, sessionEncryptionKeySize)
+		}
+		keys = append(keys, sessionKeyPair{hashKey: hashKey, encryptionKey: encKey})
+	}
+	return keys, nil
+}
+
+// cookieKeys is the key pair list for the cookie store, a nil encryption key
+// turns encryption off for that pair
+func cookieKeys(keys []sessionKeyPair) [][]byte {
+	out := make([][]byte, 0, len(keys)*2)
+	for _, k := range keys {
+		out = append(out, k.hashKey, k.encryptionKey)
+	}
+	return out
+}
+
+// refreshKey seals refresh tokens in the database, it is derived from the
+// pair so rotating the session keys rotates it too
+func (k sessionKeyPair) refreshKey() []byte {
+	return k.derivedKey("refresh token")
+}
+
+// signingSealKey seals the identity token signing keys in the database
+func (k sessionKeyPair) signingSealKey() []byte {
+	return k.derivedKey("signing key")
+}
+
+func (k sessionKeyPair) derivedKey(purpose string) []byte {
+	secret := k.encryptionKey
+	if secret == nil {
+		secret = k.hashKey
+	}
+	key := sha256.Sum256(append([]byte("discord-plays "+purpose+":"), secret...))
+	return key[:]
+}
+
+// GenerateSessionKeyPair makes a new entry for the front of SESSION_KEYS
+func GenerateSessionKeyPair() (string, error) {
+	hashKey := make([]byte, sessionHashKeySize)
+	if _, err := rand.Read(hashKey); err != nil {
+		return "", err
+	}
+	encKey := make([]byte, sessionEncryptionKeySize)
+	if _, err := rand.Read(encKey); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hashKey) + ":" + hex.EncodeToString(encKey), nil
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"github.com/discord-plays/website/structure"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSessionKeys(t *testing.T) {
+	hashKey, encKey := strings.Repeat("ab", 64), strings.Repeat("cd", 32)
+	tests := []struct {
+		name    string
+		keys    string
+		want    int
+		wantErr bool
+	}{
+		{"empty", "", 0, false},
+		{"one pair", hashKey + ":" + encKey, 1, false},
+		{"two pairs with spaces", hashKey + ":" + encKey + " , " + hashKey + ":" + encKey, 2, false},
+		{"trailing comma", hashKey + ":" + encKey + ",", 1, false},
+		{"missing encryption key", hashKey, 0, true},
+		{"short hash key", strings.Repeat("ab", 16)[2:] + ":" + encKey, 0, true},
+		{"short encryption key", hashKey + ":" + encKey[2:], 0, true},
+		{"not hex", strings.Repeat("zz", 64) + ":" + encKey, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := parseSessionKeys(tt.keys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSessionKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(keys) != tt.want {
+				t.Errorf("parseSessionKeys() gave %d pairs, want %d", len(keys), tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionKeysFromEnv(t *testing.T) {
+	pair, err := GenerateSessionKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		keys    string
+		legacy  string
+		want    int
+		wantErr bool
+	}{
+		{"session keys", pair, "", 1, false},
+		{"legacy key is read after the session keys", pair, "old-signing-key", 2, false},
+		{"only the legacy key", "", "old-signing-key", 0, true},
+		{"no keys", "", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SESSION_KEYS", tt.keys)
+			t.Setenv("SESSION_ENCRYPTION", tt.legacy)
+			keys, err := sessionKeysFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sessionKeysFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(keys) != tt.want {
+				t.Fatalf("sessionKeysFromEnv() gave %d pairs, want %d", len(keys), tt.want)
+			}
+			// New cookies are always written with the first pair
+			if len(keys) > 0 && keys[0].encryptionKey == nil {
+				t.Errorf("the first pair does not encrypt")
+			}
+		})
+	}
+}
+
+func TestOpenRefreshTokenAfterRotation(t *testing.T) {
+	oldPair, _ := GenerateSessionKeyPair()
+	newPair, _ := GenerateSessionKeyPair()
+	t.Setenv("SESSION_ENCRYPTION", "")
+	t.Setenv("SESSION_KEYS", oldPair)
+	oldSess := NewDiscordPlaysSessions()
+	sealed, err := oldSess.sealRefreshToken("refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		keys      string
+		wantIndex int
+		wantErr   bool
+	}{
+		{"before rotating", oldPair, 0, false},
+		{"after rotating", newPair + "," + oldPair, 1, false},
+		{"after removing the old pair", newPair, -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SESSION_KEYS", tt.keys)
+			token, index, err := NewDiscordPlaysSessions().openRefreshToken(sealed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("openRefreshToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if index != tt.wantIndex {
+				t.Errorf("openRefreshToken() index = %d, want %d", index, tt.wantIndex)
+			}
+			if !tt.wantErr && token != "refresh" {
+				t.Errorf("openRefreshToken() = %q, want %q", token, "refresh")
+			}
+		})
+	}
+}
+
+func TestRefreshProfileResealsRetiredKeys(t *testing.T) {
+	oldPair, _ := GenerateSessionKeyPair()
+	newPair, _ := GenerateSessionKeyPair()
+	t.Setenv("SESSION_ENCRYPTION", "")
+	t.Setenv("SESSION_KEYS", oldPair)
+	refreshToken := encodeMockUser(&mockUser{Id: "1", Username: "user"})
+	sealed, err := NewDiscordPlaysSessions().sealRefreshToken(refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SESSION_KEYS", newPair+","+oldPair)
+	dpHttp := newRedirectTestHttp()
+	dpHttp.dpSess = NewDiscordPlaysSessions()
+	dpHttp.db = newTestDb(t, &structure.LoginSession{}, &structure.User{})
+	dpHttp.identity = &mockProvider{}
+	dpHttp.publicIds = &publicIds{key: make([]byte, 32)}
+	loginSession := &structure.LoginSession{Id: "login", UserId: "1", RefreshToken: sealed, ExpiresAt: time.Now().Add(time.Hour)}
+	dpHttp.db.Create(loginSession)
+
+	if _, err := dpHttp.refreshProfile(context.Background(), loginSession); err != nil {
+		t.Fatal(err)
+	}
+	stored := &structure.LoginSession{}
+	dpHttp.db.First(stored, "id = ?", "login")
+	token, index, err := dpHttp.dpSess.openRefreshToken(stored.RefreshToken)
+	if err != nil || index != 0 || token != refreshToken {
+		t.Errorf("openRefreshToken() = %q, %d, %v, want the token sealed with the newest key", token, index, err)
+	}
+}
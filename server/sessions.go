@@ -2,10 +2,15 @@ package server
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"encoding/gob"
+	"errors"
 	"github.com/discord-plays/website/structure"
 	"github.com/google/uuid"
 	"github.com/gorilla/sessions"
+	"log"
 	"net/http"
 	"os"
 	"time"
@@ -14,19 +19,58 @@ import (
 const cookieName = "DpSession"
 
 type DiscordPlaysSessions struct {
-	store *sessions.CookieStore
+	store               *sessions.CookieStore
+	refreshKeys         [][]byte
+	signingSealKeys     [][]byte
+	idleTimeout         time.Duration
+	rememberIdleTimeout time.Duration
+	absoluteTimeout     time.Duration
 }
 
 func NewDiscordPlaysSessions() *DiscordPlaysSessions {
-	cookieSessions := sessions.NewCookieStore([]byte(os.Getenv("SESSION_ENCRYPTION")))
+	dpSess := &DiscordPlaysSessions{
+		idleTimeout:         sessionTimeoutFromEnv("SESSION_IDLE_TIMEOUT", 12*time.Hour),
+		rememberIdleTimeout: sessionTimeoutFromEnv("SESSION_REMEMBER_IDLE_TIMEOUT", 14*24*time.Hour),
+		absoluteTimeout:     sessionTimeoutFromEnv("SESSION_ABSOLUTE_TIMEOUT", 30*24*time.Hour),
+	}
+
+	keys, err := sessionKeysFromEnv()
+	if err != nil {
+		log.Fatalf("[Http::Sessions] Failed to load session keys: %s\n", err)
+	}
+	cookieSessions := sessions.NewCookieStore(cookieKeys(keys)...)
+	cookieSessions.MaxAge(int(dpSess.absoluteTimeout.Seconds()))
 	cookieSessions.Options.SameSite = http.SameSiteLaxMode
 	cookieSessions.Options.Domain = os.Getenv("COOKIE_DOMAIN")
-	return &DiscordPlaysSessions{store: cookieSessions}
+	dpSess.store = cookieSessions
+
+	for _, k := range keys {
+		dpSess.refreshKeys = append(dpSess.refreshKeys, k.refreshKey())
+		dpSess.signingSealKeys = append(dpSess.signingSealKeys, k.signingSealKey())
+	}
+	return dpSess
+}
+
+func sessionTimeoutFromEnv(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("[Http::Sessions] Invalid %s, using %s: %s\n", name, def, v)
+		return def
+	}
+	return d
 }
 
 func (dpSess *DiscordPlaysSessions) CheckLogin(req *http.Request) (*sessions.Session, *structure.DiscordMeBody, bool) {
 	sess, _ := dpSess.store.Get(req, cookieName)
 	sess.Options.SameSite = http.SameSiteLaxMode
+	// Only "remember me" logins outlive the browser
+	if remember, _ := sess.Values["RememberMe"].(bool); !remember {
+		sess.Options.MaxAge = 0
+	}
 	if dpUserBytes, ok := sess.Values["dpUser"].([]byte); ok {
 		s := bytes.NewBuffer(dpUserBytes)
 		g := gob.NewDecoder(s)
@@ -43,6 +87,25 @@ func (dpSess *DiscordPlaysSessions) CheckLogin(req *http.Request) (*sessions.Ses
 	return sess, nil, false
 }
 
+// SetUser stores the user in the session, the caller still needs to save it
+func (dpSess *DiscordPlaysSessions) SetUser(sess *sessions.Session, meBody *structure.DiscordMeBody) {
+	s := new(bytes.Buffer)
+	g := gob.NewEncoder(s)
+	if g.Encode(meBody) != nil {
+		sess.Values["dpUser"] = []byte{}
+		return
+	}
+	sess.Values["dpUser"] = s.Bytes()
+}
+
+// IdleTimeout is how long a login lasts without any activity
+func (dpSess *DiscordPlaysSessions) IdleTimeout(sess *sessions.Session) time.Duration {
+	if remember, _ := sess.Values["RememberMe"].(bool); remember {
+		return dpSess.rememberIdleTimeout
+	}
+	return dpSess.idleTimeout
+}
+
 func (dpSess *DiscordPlaysSessions) GetStateToken(sess *sessions.Session) string {
 	if stateToken, ok := sess.Values["stateToken"].(string); ok {
 		return stateToken
@@ -55,3 +118,75 @@ func (dpSess *DiscordPlaysSessions) GenerateStateToken(sess *sessions.Session) s
 	sess.Values["stateToken"] = u
 	return u
 }
+
+// CsrfSecret is the secret behind the CSRF tokens for the session, ok is false
+// if it was just made and the session needs saving
+func (dpSess *DiscordPlaysSessions) CsrfSecret(sess *sessions.Session) ([]byte, bool) {
+	if secret, ok := sess.Values["csrfToken"].([]byte); ok && len(secret) == csrfSecretSize {
+		return secret, true
+	}
+	secret := newCsrfSecret()
+	sess.Values["csrfToken"] = secret
+	return secret, false
+}
+
+// ValidCsrfToken checks an unmasked token against the session
+func (dpSess *DiscordPlaysSessions) ValidCsrfToken(sess *sessions.Session, token []byte) bool {
+	secret, ok := sess.Values["csrfToken"].([]byte)
+	return ok && csrfSecretsEqual(secret, token)
+}
+
+// sealRefreshToken uses the newest key, the rest are only for opening
+func (dpSess *DiscordPlaysSessions) sealRefreshToken(token string) ([]byte, error) {
+	return sealWithKey(dpSess.refreshKeys[0], []byte(token))
+}
+
+// openRefreshToken tries each key so tokens sealed before a rotation still
+// open, the index is above 0 for tokens which need sealing again
+func (dpSess *DiscordPlaysSessions) openRefreshToken(sealed []byte) (string, int, error) {
+	b, i, err := openWithKeys(dpSess.refreshKeys, sealed)
+	return string(b), i, err
+}
+
+// sealWithKey encrypts with AES-GCM and puts the nonce in front
+func sealWithKey(key, plain []byte) ([]byte, error) {
+	gcm, err := sealCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+// openWithKeys tries each key in turn and returns the index of the key which
+// opened it
+func openWithKeys(keys [][]byte, sealed []byte) ([]byte, int, error) {
+	err := errors.New("no sealing keys")
+	for i, key := range keys {
+		var gcm cipher.AEAD
+		gcm, err = sealCipher(key)
+		if err != nil {
+			return nil, -1, err
+		}
+		if len(sealed) < gcm.NonceSize() {
+			return nil, -1, errors.New("sealed value is too short")
+		}
+		var b []byte
+		b, err = gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+		if err == nil {
+			return b, i, nil
+		}
+	}
+	return nil, -1, err
+}
+
+func sealCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
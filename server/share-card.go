@@ -0,0 +1,258 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/discord-plays/website/res"
+	"github.com/discord-plays/website/structure"
+	"github.com/gorilla/mux"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"io/fs"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	shareCardWidth   = 1200
+	shareCardHeight  = 630
+	shareCardPadding = 60
+	shareCardLogo    = 220
+)
+
+var (
+	shareCardBackground = color.RGBA{R: 0x21, G: 0x25, B: 0x29, A: 0xff}
+)
+
+// shareCardCache holds the last rendered card for each project along with the
+// key it was rendered from
+type shareCardCache struct {
+	mu    *sync.Mutex
+	cards map[string]*shareCard
+	fonts *shareCardFonts
+}
+
+// shareCardFonts are loaded at startup, the faces are only used while holding
+// the cache lock
+type shareCardFonts struct {
+	title font.Face
+	text  font.Face
+}
+
+type shareCard struct {
+	key  string
+	data []byte
+}
+
+func newShareCardCache() *shareCardCache {
+	return &shareCardCache{mu: &sync.Mutex{}, cards: make(map[string]*shareCard)}
+}
+
+func SetupDiscordPlaysShareCards(dpHttp *DiscordPlaysHttp, router *mux.Router) {
+	router.HandleFunc("/bots/{botName}/card.png", func(rw http.ResponseWriter, req *http.Request) {
+		project, ok := getProjectItemFromName(dpHttp, mux.Vars(req)["botName"])
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		card, err := dpHttp.shareCards.get(project)
+		if err != nil {
+			log.Printf("[Http::ShareCard] Failed to render card for '%s': %s\n", *project.Code, err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+
+		etag := "\"" + card.key + "\""
+		rw.Header().Set("ETag", etag)
+		rw.Header().Set("Cache-Control", "public, max-age=3600")
+		if etagMatches(req.Header.Get("If-None-Match"), etag) {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+		rw.Header().Set("Content-Type", "image/png")
+		rw.Header().Set("Content-Length", strconv.Itoa(len(card.data)))
+		_, _ = rw.Write(card.data)
+	}).Methods(http.MethodGet, http.MethodHead)
+}
+
+// shareCardUrl is the address of the generated share image for a project
+func (dpHttp *DiscordPlaysHttp) shareCardUrl(project *structure.ProjectItem) string {
+	return fmt.Sprintf("%s/bots/%s/card.png", dpHttp.rootUrl(), *project.Code)
+}
+
+// get returns the cached card for a project, the card is rendered again when
+// the project details or its banner and logo have changed
+func (c *shareCardCache) get(project *structure.ProjectItem) (*shareCard, error) {
+	assets := res.GetAssetsFilesystem()
+	banner, _ := fs.ReadFile(assets, fmt.Sprintf("projects/%s/banner.png", *project.Code))
+	logo, _ := fs.ReadFile(assets, fmt.Sprintf("projects/%s/logo.png", *project.Code))
+
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00", *project.Code, *project.Name, *project.SubText, project.UpdatedAt.UnixNano())
+	h.Write(banner)
+	h.Write([]byte{0})
+	h.Write(logo)
+	key := hex.EncodeToString(h.Sum(nil))[:32]
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if card, ok := c.cards[*project.Code]; ok && card.key == key {
+		return card, nil
+	}
+
+	if logo == nil {
+		logo, _ = fs.ReadFile(assets, "logo.png")
+	}
+	data, err := renderShareCard(c.fonts, project, decodeImageBytes(banner), decodeImageBytes(logo))
+	if err != nil {
+		return nil, err
+	}
+	card := &shareCard{key: key, data: data}
+	c.cards[*project.Code] = card
+	return card, nil
+}
+
+func decodeImageBytes(b []byte) image.Image {
+	if b == nil {
+		return nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+func loadShareCardFonts() (*shareCardFonts, error) {
+	title, err := parseShareCardFont(gobold.TTF, 64)
+	if err != nil {
+		return nil, err
+	}
+	text, err := parseShareCardFont(goregular.TTF, 36)
+	if err != nil {
+		return nil, err
+	}
+	return &shareCardFonts{title: title, text: text}, nil
+}
+
+func parseShareCardFont(ttf []byte, size float64) (font.Face, error) {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+func renderShareCard(fonts *shareCardFonts, project *structure.ProjectItem, banner, logo image.Image) ([]byte, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, shareCardWidth, shareCardHeight))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(shareCardBackground), image.Point{}, draw.Src)
+	if banner != nil {
+		draw.CatmullRom.Scale(dst, dst.Bounds(), banner, coverRect(banner.Bounds(), shareCardWidth, shareCardHeight), draw.Src, nil)
+	}
+
+	// Darken the lower part of the banner so the text stays readable
+	for y := shareCardHeight / 3; y < shareCardHeight; y++ {
+		a := uint8(240 * (y - shareCardHeight/3) / (shareCardHeight - shareCardHeight/3))
+		row := image.Rect(0, y, shareCardWidth, y+1)
+		draw.Draw(dst, row, image.NewUniform(color.NRGBA{A: a}), image.Point{}, draw.Over)
+	}
+
+	logoRect := image.Rect(shareCardPadding, shareCardHeight-shareCardPadding-shareCardLogo, shareCardPadding+shareCardLogo, shareCardHeight-shareCardPadding)
+	if logo != nil {
+		draw.Draw(dst, logoRect.Inset(-4), image.White, image.Point{}, draw.Src)
+		draw.CatmullRom.Scale(dst, logoRect, logo, coverRect(logo.Bounds(), shareCardLogo, shareCardLogo), draw.Over, nil)
+	}
+
+	textX := logoRect.Max.X + 40
+	textWidth := shareCardWidth - shareCardPadding - textX
+	titleLines := wrapText(fonts.title, "Discord Plays "+*project.Name, textWidth, 2)
+	textLines := wrapText(fonts.text, *project.SubText, textWidth, 2)
+
+	// Lay the text out from the bottom edge of the logo upwards
+	y := logoRect.Max.Y - 8
+	y -= len(textLines) * 44
+	textY := y
+	y -= 12 + (len(titleLines)-1)*72
+	for _, line := range titleLines {
+		drawText(dst, fonts.title, color.White, textX, y, line)
+		y += 72
+	}
+	for _, line := range textLines {
+		textY += 44
+		drawText(dst, fonts.text, color.RGBA{R: 0xce, G: 0xd4, B: 0xda, A: 0xff}, textX, textY, line)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// coverRect finds the centred part of src with the same aspect ratio as w by h
+func coverRect(src image.Rectangle, w, h int) image.Rectangle {
+	sw, sh := src.Dx(), src.Dy()
+	if sw*h > sh*w {
+		cw := sh * w / h
+		x := src.Min.X + (sw-cw)/2
+		return image.Rect(x, src.Min.Y, x+cw, src.Max.Y)
+	}
+	ch := sw * h / w
+	y := src.Min.Y + (sh-ch)/2
+	return image.Rect(src.Min.X, y, src.Max.X, y+ch)
+}
+
+func drawText(dst draw.Image, face font.Face, c color.Color, x, y int, text string) {
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: face, Dot: fixed.P(x, y)}
+	d.DrawString(text)
+}
+
+// wrapText splits text into lines no wider than width, the last line is cut
+// short with an ellipsis when there are more than maxLines
+func wrapText(face font.Face, text string, width, maxLines int) []string {
+	limit := fixed.I(width)
+	words := strings.Fields(text)
+	lines := make([]string, 0, maxLines)
+	line := ""
+	for i, w := range words {
+		next := strings.TrimSpace(line + " " + w)
+		if line != "" && font.MeasureString(face, next) > limit {
+			lines = append(lines, line)
+			line = w
+			if len(lines) == maxLines-1 {
+				line = strings.Join(words[i:], " ")
+				break
+			}
+			continue
+		}
+		line = next
+	}
+	if line != "" {
+		if font.MeasureString(face, line) > limit {
+			for line != "" && font.MeasureString(face, line+"…") > limit {
+				line = strings.TrimSpace(trimLastRune(line))
+			}
+			line += "…"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func trimLastRune(a string) string {
+	r := []rune(a)
+	return string(r[:len(r)-1])
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"fmt"
+	"github.com/discord-plays/website/structure"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	teamAvatarDir     = ".data/avatars"
+	maxTeamAvatarSize = 2 << 20
+	teamMemberLinkMax = 4
+)
+
+const defaultAboutText = `These Discord bots allow you to play various games on Discord, including things like Minesweeper, or even the card game from Fallout: New Vegas, Caravan.
+
+We are making the more niche ideas for bots, and prefer to make bots that don't already exist. That being said, if you have an idea for a bot, [let us know](/ideas)!
+
+More bots will be added in the future. We are a small team, and these bots take quite a lot of time to make to the standards that you see here.`
+
+func SetupDiscordPlaysTeam(dpHttp *DiscordPlaysHttp, rootRouter *mux.Router, adminRouter *mux.Router) {
+	rootRouter.HandleFunc("/about", func(rw http.ResponseWriter, req *http.Request) {
+		_, dpUser, _ := dpHttp.dpSess.CheckLogin(req)
+		members, err := dpHttp.loadTeamMembers()
+		if err != nil {
+			log.Printf("[Http::Team] Failed to load team members: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		dpHttp.generatePage(rw, req, dpUser, dpHttp.newPageMeta(req, "About", "About the Discord Plays bots and the team making them."), "about.go.html", struct {
+			About   string
+			Members []*structure.TeamMember
+		}{
+			About:   dpHttp.getSiteText(structure.SiteTextAbout),
+			Members: members,
+		})
+	}).Methods(http.MethodGet)
+	rootRouter.HandleFunc("/team/avatars/{name}", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeFile(rw, req, filepath.Join(teamAvatarDir, filepath.Base(mux.Vars(req)["name"])))
+	}).Methods(http.MethodGet)
+
+	adminRouter.HandleFunc("/team", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		members, err := dpHttp.loadTeamMembers()
+		if err != nil {
+			log.Printf("[Http::Team] Failed to load team members: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		dpHttp.generatePage(rw, req, dpUser, dpHttp.newPageMeta(req, "Discord Plays Admin - Team", ""), "admin-team.go.html", struct {
+			About      string
+			Members    []*structure.TeamMember
+			RootDomain string
+		}{
+			About:      dpHttp.getSiteText(structure.SiteTextAbout),
+			Members:    members,
+			RootDomain: dpHttp.rootUrl(),
+		})
+	})).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/team/about", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		text := &structure.SiteText{Key: structure.SiteTextAbout, Value: strings.TrimSpace(req.PostFormValue("about"))}
+		if err := dpHttp.db.Save(text).Error; err != nil {
+			log.Printf("[Http::Team] Failed to save about text: %s\n", err)
+			dpHttp.writeError(rw, req, http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(rw, req, "/team", http.StatusSeeOther)
+	})).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/team/new", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		dpHttp.generateTeamMemberEditor(rw, req, dpUser, &structure.TeamMember{}, "")
+	})).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/team", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		saveTeamMember(dpHttp, rw, req, dpUser, &structure.TeamMember{})
+	})).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/team/{id:[0-9]+}", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		member, ok := getTeamMemberFromVars(dpHttp, req)
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		dpHttp.generateTeamMemberEditor(rw, req, dpUser, member, "")
+	})).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/team/{id:[0-9]+}", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		member, ok := getTeamMemberFromVars(dpHttp, req)
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		saveTeamMember(dpHttp, rw, req, dpUser, member)
+	})).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/team/{id:[0-9]+}/delete", requireAdmin(dpHttp, func(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody) {
+		member, ok := getTeamMemberFromVars(dpHttp, req)
+		if !ok {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		dpHttp.db.Where("team_member_id = ?", member.ID).Delete(&structure.TeamMemberLink{})
+		dpHttp.db.Delete(member)
+		http.Redirect(rw, req, "/team", http.StatusSeeOther)
+	})).Methods(http.MethodPost)
+}
+
+// seedTeam fills in the original about page content the first time the site
+// starts with an empty team table
+func (dpHttp *DiscordPlaysHttp) seedTeam() {
+	var count int64
+	dpHttp.db.Model(&structure.TeamMember{}).Unscoped().Count(&count)
+	if count > 0 {
+		return
+	}
+	log.Printf("[Http::Team] Seeding team members\n")
+	dpHttp.db.Save(&structure.SiteText{Key: structure.SiteTextAbout, Value: defaultAboutText})
+	dpHttp.db.Create([]*structure.TeamMember{
+		{
+			Name:     "Melon",
+			Role:     "Developer",
+			Bio:      "Develops Minecraft mods, KTaNE mods and Discord bots.",
+			Avatar:   "/assets/team/melon.png",
+			Position: 0,
+			Links: []*structure.TeamMemberLink{
+				{Label: "mrmelon54.com", Url: "https://mrmelon54.com", Position: 0},
+				{Label: "github.com/mrmelon54", Url: "https://github.com/mrmelon54", Position: 1},
+			},
+		},
+		{
+			Name:     "Kiki",
+			Role:     "Developer",
+			Bio:      "...",
+			Avatar:   "/assets/team/kiki.png",
+			Position: 1,
+			Links: []*structure.TeamMemberLink{
+				{Label: "kikicat123.ca", Url: "https://kikicat123.ca", Position: 0},
+				{Label: "github.com/kikithecat12345", Url: "https://github.com/kikithecat12345", Position: 1},
+			},
+		},
+	})
+}
+
+func (dpHttp *DiscordPlaysHttp) loadTeamMembers() ([]*structure.TeamMember, error) {
+	var members []*structure.TeamMember
+	err := dpHttp.db.Preload("Links", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position asc")
+	}).Order("position asc, id asc").Find(&members).Error
+	return members, err
+}
+
+func (dpHttp *DiscordPlaysHttp) getSiteText(key string) string {
+	text := &structure.SiteText{}
+	dpHttp.db.Where(&structure.SiteText{Key: key}).Limit(1).Find(text)
+	return text.Value
+}
+
+func getTeamMemberFromVars(dpHttp *DiscordPlaysHttp, req *http.Request) (*structure.TeamMember, bool) {
+	id, err := strconv.ParseUint(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	member := &structure.TeamMember{}
+	if dpHttp.db.Preload("Links", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position asc")
+	}).Limit(1).Find(member, id).RowsAffected == 0 {
+		return nil, false
+	}
+	return member, true
+}
+
+func saveTeamMember(dpHttp *DiscordPlaysHttp, rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody, member *structure.TeamMember) {
+	req.Body = http.MaxBytesReader(rw, req.Body, maxTeamAvatarSize+(1<<20))
+	if err := req.ParseMultipartForm(maxTeamAvatarSize); err != nil {
+		dpHttp.generateTeamMemberEditor(rw, req, dpUser, member, "The avatar is too large, the limit is 2MB")
+		return
+	}
+	member.Name = strings.TrimSpace(req.PostFormValue("name"))
+	member.Role = strings.TrimSpace(req.PostFormValue("role"))
+	member.Bio = strings.TrimSpace(req.PostFormValue("bio"))
+	member.Position, _ = strconv.Atoi(req.PostFormValue("position"))
+	if member.Name == "" {
+		dpHttp.generateTeamMemberEditor(rw, req, dpUser, member, "A name is required")
+		return
+	}
+
+	labels := req.PostForm["linkLabel"]
+	urls := req.PostForm["linkUrl"]
+	links := make([]*structure.TeamMemberLink, 0, len(labels))
+	for i := 0; i < len(labels) && i < len(urls); i++ {
+		label := strings.TrimSpace(labels[i])
+		u := strings.TrimSpace(urls[i])
+		if label == "" && u == "" {
+			continue
+		}
+		if pu, err := url.Parse(u); err != nil || (pu.Scheme != "https" && pu.Scheme != "http") || label == "" {
+			member.Links = links
+			dpHttp.generateTeamMemberEditor(rw, req, dpUser, member, fmt.Sprintf("Link %d needs a label and an http(s) URL", i+1))
+			return
+		}
+		links = append(links, &structure.TeamMemberLink{Label: label, Url: u, Position: len(links)})
+	}
+	member.Links = links
+
+	if f, _, err := req.FormFile("avatar"); err == nil {
+		name, err := saveUploadedImage(f, teamAvatarDir)
+		_ = f.Close()
+		if err != nil {
+			log.Printf("[Http::Team] Failed to save avatar: %s\n", err)
+			dpHttp.generateTeamMemberEditor(rw, req, dpUser, member, "Avatars must be a PNG, JPEG, GIF or WebP image")
+			return
+		}
+		member.Avatar = "/team/avatars/" + name
+	}
+
+	err := dpHttp.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Links").Save(member).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("team_member_id = ?", member.ID).Delete(&structure.TeamMemberLink{}).Error; err != nil {
+			return err
+		}
+		for _, l := range links {
+			l.TeamMemberID = member.ID
+		}
+		if len(links) == 0 {
+			return nil
+		}
+		return tx.Create(links).Error
+	})
+	if err != nil {
+		log.Printf("[Http::Team] Failed to save team member: %s\n", err)
+		dpHttp.writeError(rw, req, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(rw, req, "/team", http.StatusSeeOther)
+}
+
+func (dpHttp *DiscordPlaysHttp) generateTeamMemberEditor(rw http.ResponseWriter, req *http.Request, dpUser *structure.DiscordMeBody, member *structure.TeamMember, errMsg string) {
+	// Always leave some empty rows to add new links
+	links := append([]*structure.TeamMemberLink{}, member.Links...)
+	for len(links) < len(member.Links)+2 || len(links) < teamMemberLinkMax {
+		links = append(links, &structure.TeamMemberLink{})
+	}
+	dpHttp.generatePage(rw, req, dpUser, dpHttp.newPageMeta(req, "Discord Plays Admin - Edit Team Member", ""), "admin-team-edit.go.html", struct {
+		Member     *structure.TeamMember
+		Links      []*structure.TeamMemberLink
+		RootDomain string
+		Error      string
+	}{
+		Member:     member,
+		Links:      links,
+		RootDomain: dpHttp.rootUrl(),
+		Error:      errMsg,
+	})
+}
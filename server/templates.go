@@ -0,0 +1,155 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+)
+
+const layoutTemplate = "layout.go.html"
+
+// layoutPartials are parsed into the layout, every other file is a page
+var layoutPartials = []string{layoutTemplate, "head.go.html", "nav.go.html"}
+
+// pageLayout is the data for the layout, the page itself gets Body
+type pageLayout struct {
+	Head interface{}
+	Nav  interface{}
+	Body interface{}
+}
+
+// pageTemplates is every page parsed once at startup, each page is a clone of
+// the layout with the page parsed into its body block
+type pageTemplates struct {
+	fsys    fs.FS
+	reload  bool
+	assets  *assetManifest
+	rwSync  *sync.RWMutex
+	pages   map[string]*template.Template
+	modTime time.Time
+}
+
+func newPageTemplates(fsys fs.FS, reload bool, assets *assetManifest) (*pageTemplates, error) {
+	t := &pageTemplates{fsys: fsys, reload: reload, assets: assets, rwSync: &sync.RWMutex{}}
+	modTime, err := t.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	t.pages, err = t.parse()
+	t.modTime = modTime
+	return t, err
+}
+
+func pageTemplateFuncs(assets *assetManifest) template.FuncMap {
+	funcs := template.FuncMap{
+		"mod": func(i, j int) int {
+			return i % j
+		},
+		"markdown": renderMarkdown,
+		"asset":    assets.Url,
+	}
+	for k, v := range csrfFuncs {
+		funcs[k] = v
+	}
+	return funcs
+}
+
+func (t *pageTemplates) parse() (map[string]*template.Template, error) {
+	layout, err := template.New(layoutTemplate).Funcs(pageTemplateFuncs(t.assets)).ParseFS(t.fsys, layoutPartials...)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := fs.Glob(t.fsys, "*.go.html")
+	if err != nil {
+		return nil, err
+	}
+	pages := make(map[string]*template.Template)
+	for _, name := range files {
+		if isLayoutPartial(name) {
+			continue
+		}
+		b, err := fs.ReadFile(t.fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		page, err := layout.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = page.New("body").Parse(string(b)); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		pages[name] = page
+	}
+	return pages, nil
+}
+
+func isLayoutPartial(name string) bool {
+	for _, i := range layoutPartials {
+		if i == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Page finds a parsed page, debug builds parse the pages again first if the
+// files have changed
+func (t *pageTemplates) Page(name string) (*template.Template, error) {
+	if t.reload {
+		if err := t.reloadIfChanged(); err != nil {
+			return nil, err
+		}
+	}
+	t.rwSync.RLock()
+	defer t.rwSync.RUnlock()
+	page, ok := t.pages[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown page template: %s", name)
+	}
+	return page, nil
+}
+
+func (t *pageTemplates) reloadIfChanged() error {
+	modTime, err := t.latestModTime()
+	if err != nil {
+		return err
+	}
+	t.rwSync.Lock()
+	defer t.rwSync.Unlock()
+	if !modTime.After(t.modTime) {
+		return nil
+	}
+	pages, err := t.parse()
+	if err != nil {
+		return err
+	}
+	t.pages = pages
+	t.modTime = modTime
+	return nil
+}
+
+func (t *pageTemplates) latestModTime() (time.Time, error) {
+	var latest time.Time
+	entries, err := fs.ReadDir(t.fsys, ".")
+	if err != nil {
+		return latest, err
+	}
+	for _, e := range entries {
+		if path.Ext(e.Name()) != ".html" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/discord-plays/website/structure"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	identityTokenLifetime = 10 * time.Minute
+	signingKeyLifetime    = 30 * 24 * time.Hour
+	signingKeyGracePeriod = 24 * time.Hour
+	identityTokenAudience = "discord-plays"
+)
+
+// tokenSigner issues ES256 JSON web tokens, keys are kept in the database and
+// replaced once they are older than signingKeyLifetime
+type tokenSigner struct {
+	db       *gorm.DB
+	sealKeys [][]byte
+	rwSync   *sync.RWMutex
+	current  *signingKey
+	keys     []*signingKey
+}
+
+type signingKey struct {
+	kid       string
+	key       *ecdsa.PrivateKey
+	createdAt time.Time
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+// newTokenSigner seals the private keys with the first of sealKeys, the rest
+// are for opening keys sealed before the session keys were rotated
+func newTokenSigner(db *gorm.DB, sealKeys [][]byte) (*tokenSigner, error) {
+	t := &tokenSigner{db: db, sealKeys: sealKeys, rwSync: &sync.RWMutex{}}
+	if err := t.rotate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// rotateLoop checks for expired keys until the stop channel is closed
+func (t *tokenSigner) rotateLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := t.rotate(); err != nil {
+				log.Printf("[Http::Tokens] Failed to rotate signing keys: %s\n", err)
+			}
+		}
+	}
+}
+
+// rotate retires the current key once it is too old, creates a new key if
+// there is no active one and drops retired keys after the grace period
+func (t *tokenSigner) rotate() error {
+	t.rwSync.Lock()
+	defer t.rwSync.Unlock()
+
+	now := time.Now()
+	if err := t.db.Model(&structure.SigningKey{}).Where("retired_at IS NULL AND created_at < ?", now.Add(-signingKeyLifetime)).Update("retired_at", now).Error; err != nil {
+		return err
+	}
+	if err := t.db.Where("retired_at < ?", now.Add(-signingKeyGracePeriod)).Delete(&structure.SigningKey{}).Error; err != nil {
+		return err
+	}
+
+	var rows []*structure.SigningKey
+	if err := t.db.Order("created_at desc").Find(&rows).Error; err != nil {
+		return err
+	}
+	keys := make([]*signingKey, 0, len(rows)+1)
+	var current *signingKey
+	for _, r := range rows {
+		ecKey, err := t.openSigningKey(r)
+		if err != nil {
+			// Tokens signed with it can't be checked anyway
+			log.Printf("[Http::Tokens] Dropping signing key '%s' which can't be opened: %s\n", r.Kid, err)
+			if err = t.db.Delete(r).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		sk := &signingKey{kid: r.Kid, key: ecKey, createdAt: r.CreatedAt}
+		keys = append(keys, sk)
+		if current == nil && r.RetiredAt == nil {
+			current = sk
+		}
+	}
+
+	if current == nil {
+		sk, err := t.generateSigningKey()
+		if err != nil {
+			return err
+		}
+		log.Printf("[Http::Tokens] Generated a new signing key\n")
+		keys = append([]*signingKey{sk}, keys...)
+		current = sk
+	}
+	t.keys = keys
+	t.current = current
+	return nil
+}
+
+func (t *tokenSigner) generateSigningKey() (*signingKey, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealWithKey(t.sealKeys[0], der)
+	if err != nil {
+		return nil, err
+	}
+	row := &structure.SigningKey{Kid: uuid.NewString(), PrivateKey: sealed}
+	if err = t.db.Create(row).Error; err != nil {
+		return nil, err
+	}
+	return &signingKey{kid: row.Kid, key: priv, createdAt: row.CreatedAt}, nil
+}
+
+// openSigningKey unseals a stored key, keys stored before they were sealed or
+// sealed with an older session key are sealed again with the newest one
+func (t *tokenSigner) openSigningKey(r *structure.SigningKey) (*ecdsa.PrivateKey, error) {
+	der, i, err := openWithKeys(t.sealKeys, r.PrivateKey)
+	if err != nil {
+		der = r.PrivateKey
+	}
+	k, parseErr := x509.ParsePKCS8PrivateKey(der)
+	if parseErr != nil {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("parse signing key: %w", parseErr)
+	}
+	ecKey, ok := k.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an ECDSA key")
+	}
+
+	if i != 0 {
+		sealed, err := sealWithKey(t.sealKeys[0], der)
+		if err != nil {
+			return nil, err
+		}
+		if err = t.db.Model(r).Update("private_key", sealed).Error; err != nil {
+			return nil, err
+		}
+	}
+	return ecKey, nil
+}
+
+// Sign encodes and signs claims with the current key
+func (t *tokenSigner) Sign(claims interface{}) (string, error) {
+	t.rwSync.RLock()
+	key := t.current
+	t.rwSync.RUnlock()
+
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "typ": "JWT", "kid": key.kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Verify checks the signature of a token made by Sign and decodes its claims,
+// expiry and audience checks are left to the caller
+func (t *tokenSigner) Verify(token string, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed token")
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return err
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return err
+	}
+	if header.Alg != "ES256" {
+		return fmt.Errorf("unexpected algorithm '%s'", header.Alg)
+	}
+
+	t.rwSync.RLock()
+	var key *signingKey
+	for _, k := range t.keys {
+		if k.kid == header.Kid {
+			key = k
+			break
+		}
+	}
+	t.rwSync.RUnlock()
+	if key == nil {
+		return fmt.Errorf("unknown key '%s'", header.Kid)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return fmt.Errorf("malformed signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(&key.key.PublicKey, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return fmt.Errorf("invalid signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, claims)
+}
+
+// JWKS lists the public half of every key which may have signed a token that
+// has not expired yet
+func (t *tokenSigner) JWKS() interface{} {
+	t.rwSync.RLock()
+	defer t.rwSync.RUnlock()
+	keys := make([]jwk, 0, len(t.keys))
+	for _, k := range t.keys {
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		k.key.PublicKey.X.FillBytes(x)
+		k.key.PublicKey.Y.FillBytes(y)
+		keys = append(keys, jwk{
+			Kty: "EC",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(x),
+			Y:   base64.RawURLEncoding.EncodeToString(y),
+			Kid: k.kid,
+			Use: "sig",
+			Alg: "ES256",
+		})
+	}
+	return struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: keys}
+}
+
+// issueIdentityToken creates a short-lived token describing the logged in user
+func (dpHttp *DiscordPlaysHttp) issueIdentityToken(meBody *structure.DiscordMeBody) (string, time.Time, error) {
+	dpBody := dpHttp.convertToDpBody(meBody)
+	now := time.Now()
+	exp := now.Add(identityTokenLifetime)
+	token, err := dpHttp.tokens.Sign(&structure.IdentityClaims{
+		Issuer:        dpHttp.idUrl(),
+		Subject:       dpBody.Id,
+		LegacySubject: dpBody.LegacyId,
+		Audience:      identityTokenAudience,
+		IssuedAt:      now.Unix(),
+		ExpiresAt:     exp.Unix(),
+		Username:      dpBody.Username,
+		DisplayName:   dpBody.DisplayName,
+		Admin:         dpBody.Admin,
+		Member:        dpBody.Member,
+	})
+	return token, exp, err
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/discord-plays/website/structure"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSealKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newTestTokenSigner(t *testing.T) *tokenSigner {
+	t.Helper()
+	signer, err := newTokenSigner(newTestDb(t, &structure.SigningKey{}), [][]byte{newTestSealKey(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+type testClaims struct {
+	Subject string `json:"sub"`
+}
+
+func TestTokenSignerVerify(t *testing.T) {
+	signer := newTestTokenSigner(t)
+	other := newTestTokenSigner(t)
+	token, err := signer.Sign(&testClaims{Subject: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := other.Sign(&testClaims{Subject: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	encode := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"signed token", token, false},
+		{"changed claims", parts[0] + "." + encode(&testClaims{Subject: "admin"}) + "." + parts[2], true},
+		{"changed signature", parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(make([]byte, 64)), true},
+		{"short signature", parts[0] + "." + parts[1] + "." + parts[2][:20], true},
+		{"no algorithm", encode(map[string]string{"alg": "none", "kid": signer.current.kid}) + "." + parts[1] + ".", true},
+		{"symmetric algorithm", encode(map[string]string{"alg": "HS256", "kid": signer.current.kid}) + "." + parts[1] + "." + parts[2], true},
+		{"key from another signer", otherToken, true},
+		{"two parts", parts[0] + "." + parts[1], true},
+		{"empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &testClaims{}
+			err := signer.Verify(tt.token, claims)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && claims.Subject != "user" {
+				t.Errorf("Verify() subject = %q, want %q", claims.Subject, "user")
+			}
+		})
+	}
+}
+
+// TestTokenSignerJWKS checks a token with only the published key, the way a
+// project would
+func TestTokenSignerJWKS(t *testing.T) {
+	signer := newTestTokenSigner(t)
+	token, err := signer.Sign(&testClaims{Subject: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(signer.JWKS())
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.Unmarshal(b, &set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 1 {
+		t.Fatalf("JWKS has %d keys, want 1", len(set.Keys))
+	}
+	k := set.Keys[0]
+	if k.Kty != "EC" || k.Crv != "P-256" || k.Alg != "ES256" || k.Use != "sig" || k.Kid != signer.current.kid {
+		t.Errorf("JWKS key = %+v", k)
+	}
+	x, _ := base64.RawURLEncoding.DecodeString(k.X)
+	y, _ := base64.RawURLEncoding.DecodeString(k.Y)
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+
+	parts := strings.Split(token, ".")
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Errorf("the token does not verify with the published key")
+	}
+}
+
+func TestTokenSignerRotate(t *testing.T) {
+	signer := newTestTokenSigner(t)
+	first := signer.current.kid
+	token, err := signer.Sign(&testClaims{Subject: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		age      func()
+		wantKeys int
+		wantOld  bool
+	}{
+		{"new key", func() {}, 1, true},
+		{"past its lifetime", func() {
+			signer.db.Model(&structure.SigningKey{}).Where("kid = ?", first).Update("created_at", time.Now().Add(-signingKeyLifetime-time.Hour))
+		}, 2, true},
+		{"past the grace period", func() {
+			signer.db.Model(&structure.SigningKey{}).Where("kid = ?", first).Update("retired_at", time.Now().Add(-signingKeyGracePeriod-time.Hour))
+		}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.age()
+			if err := signer.rotate(); err != nil {
+				t.Fatal(err)
+			}
+			if len(signer.keys) != tt.wantKeys {
+				t.Errorf("%d keys, want %d", len(signer.keys), tt.wantKeys)
+			}
+			err := signer.Verify(token, &testClaims{})
+			if (err == nil) != tt.wantOld {
+				t.Errorf("Verify() of a token from the first key error = %v, want it to verify %v", err, tt.wantOld)
+			}
+			if tt.wantKeys > 1 && signer.current.kid == first {
+				t.Errorf("the retired key is still used for signing")
+			}
+		})
+	}
+}
+
+func TestTokenSignerSealsKeys(t *testing.T) {
+	oldSealKey, newSealKey := newTestSealKey(t), newTestSealKey(t)
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	sealedWithOld, _ := sealWithKey(oldSealKey, der)
+
+	tests := []struct {
+		name     string
+		stored   []byte
+		wantKept bool
+	}{
+		{"stored before keys were sealed", der, true},
+		{"sealed with a retired session key", sealedWithOld, true},
+		{"sealed with a removed session key", func() []byte {
+			b, _ := sealWithKey(newTestSealKey(t), der)
+			return b
+		}(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDb(t, &structure.SigningKey{})
+			db.Create(&structure.SigningKey{Kid: "stored", PrivateKey: tt.stored})
+			signer, err := newTokenSigner(db, [][]byte{newSealKey, oldSealKey})
+			if err != nil {
+				t.Fatal(err)
+			}
+			row := &structure.SigningKey{}
+			found := db.First(row, "kid = ?", "stored").Error == nil
+			if found != tt.wantKept {
+				t.Fatalf("stored key kept = %v, want %v", found, tt.wantKept)
+			}
+			if !found {
+				if signer.current == nil || signer.current.kid == "stored" {
+					t.Errorf("no new key was made to replace the dropped one")
+				}
+				return
+			}
+			if signer.current.kid != "stored" {
+				t.Errorf("current key = %q, want the stored key", signer.current.kid)
+			}
+			opened, i, err := openWithKeys([][]byte{newSealKey}, row.PrivateKey)
+			if err != nil || i != 0 || string(opened) != string(der) {
+				t.Errorf("the stored key was not sealed again with the newest key")
+			}
+		})
+	}
+}
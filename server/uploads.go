@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+var uploadedImageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// saveUploadedImage checks the upload is an image and writes it to dir under a
+// random name
+func saveUploadedImage(f io.ReadSeeker, dir string) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	ext, ok := uploadedImageExtensions[http.DetectContentType(head[:n])]
+	if !ok {
+		return "", fmt.Errorf("unsupported image type")
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	name := uuid.NewString() + ext
+	out, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, f)
+	if err2 := out.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		_ = os.Remove(filepath.Join(dir, name))
+		return "", err
+	}
+	return name, nil
+}
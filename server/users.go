@@ -0,0 +1,67 @@
+package server
+
+import (
+	"github.com/discord-plays/website/structure"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm/clause"
+	"net/http"
+)
+
+func SetupDiscordPlaysUsers(dpHttp *DiscordPlaysHttp, rootRouter *mux.Router) {
+	rootRouter.HandleFunc("/users/{publicId}", func(rw http.ResponseWriter, req *http.Request) {
+		_, dpUser, _ := dpHttp.dpSess.CheckLogin(req)
+		user := &structure.User{}
+		err := dpHttp.db.Preload("LinkedIdentities").First(user, "public_id = ?", mux.Vars(req)["publicId"]).Error
+		// Hidden profiles can still be seen by their owner
+		if err != nil || (!user.PublicProfile && (dpUser == nil || dpUser.Id != user.DiscordId)) {
+			dpHttp.notFound(rw, req)
+			return
+		}
+		identities := user.LinkedIdentities
+		if !user.ShowLinkedAccounts {
+			identities = nil
+		}
+		meBody := user.MeBody()
+		profile := dpHttp.convertToDpBody(meBody)
+		meta := dpHttp.newPageMeta(req, profile.DisplayName, profile.DisplayName+" on Discord Plays")
+		meta.NoIndex = !user.PublicProfile
+		dpHttp.generatePage(rw, req, dpUser, meta, "user-profile.go.html", struct {
+			Profile    *structure.DiscordPlaysUserBody
+			Identities []structure.LinkedIdentity
+			Providers  map[string]LinkedAccountProvider
+		}{
+			Profile:    profile,
+			Identities: identities,
+			Providers:  dpHttp.linkProviders,
+		})
+	}).Methods(http.MethodGet)
+}
+
+// saveUser keeps the users table up to date with the latest Discord profile
+func (dpHttp *DiscordPlaysHttp) saveUser(meBody *structure.DiscordMeBody) (*structure.User, error) {
+	user := &structure.User{
+		DiscordId:     meBody.Id,
+		PublicId:      dpHttp.publicIds.Id(meBody.Id),
+		Username:      meBody.Username,
+		GlobalName:    meBody.GlobalName,
+		Discriminator: meBody.Discriminator,
+		Avatar:        meBody.Avatar,
+		Banner:        meBody.Banner,
+	}
+	err := dpHttp.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "discord_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"username", "global_name", "discriminator", "avatar", "banner", "updated_at"}),
+	}).Create(user).Error
+	if err != nil {
+		return nil, err
+	}
+	// The upsert does not fill in the ID of existing users
+	err = dpHttp.db.First(user, "discord_id = ?", meBody.Id).Error
+	return user, err
+}
+
+func (dpHttp *DiscordPlaysHttp) findUser(discordId string) (*structure.User, error) {
+	user := &structure.User{}
+	err := dpHttp.db.Preload("LinkedIdentities").First(user, "discord_id = ?", discordId).Error
+	return user, err
+}
@@ -0,0 +1,16 @@
+package structure
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// ApiKey lets a Discord Plays bot call the bot API, only a hash of the key is
+// kept
+type ApiKey struct {
+	gorm.Model
+	Name       string
+	KeyHash    string `gorm:"uniqueIndex"`
+	LastUsedAt *time.Time
+	Disabled   bool
+}
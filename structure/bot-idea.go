@@ -0,0 +1,44 @@
+package structure
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	BotIdeaOpen     = "open"
+	BotIdeaPlanned  = "planned"
+	BotIdeaRejected = "rejected"
+	BotIdeaShipped  = "shipped"
+)
+
+var BotIdeaStatuses = []string{BotIdeaOpen, BotIdeaPlanned, BotIdeaRejected, BotIdeaShipped}
+
+type BotIdea struct {
+	gorm.Model
+	Title         string
+	Description   string
+	AuthorId      string
+	AuthorName    string
+	Status        string
+	Hidden        bool
+	ProjectItemID *uint
+	ProjectItem   *ProjectItem
+	Votes         int  `gorm:"-"`
+	Voted         bool `gorm:"-"`
+}
+
+type BotIdeaVote struct {
+	BotIdeaID uint   `gorm:"primaryKey;autoIncrement:false"`
+	UserId    string `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+func IsBotIdeaStatus(a string) bool {
+	for _, i := range BotIdeaStatuses {
+		if i == a {
+			return true
+		}
+	}
+	return false
+}
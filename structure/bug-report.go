@@ -0,0 +1,35 @@
+package structure
+
+import "gorm.io/gorm"
+
+const (
+	BugReportOpen          = "open"
+	BugReportInvestigating = "investigating"
+	BugReportResolved      = "resolved"
+	BugReportClosed        = "closed"
+)
+
+var BugReportStatuses = []string{BugReportOpen, BugReportInvestigating, BugReportResolved, BugReportClosed}
+
+type BugReport struct {
+	gorm.Model
+	ProjectItemID uint
+	ProjectItem   *ProjectItem
+	ReporterId    string
+	ReporterName  string
+	Title         string
+	Description   string
+	Screenshot    string
+	Status        string
+	Assignee      string
+	Notes         string
+}
+
+func IsBugReportStatus(a string) bool {
+	for _, i := range BugReportStatuses {
+		if i == a {
+			return true
+		}
+	}
+	return false
+}
@@ -5,7 +5,27 @@ import "time"
 type DiscordMeBody struct {
 	Id            string    `json:"id"`
 	Username      string    `json:"username"`
+	GlobalName    string    `json:"global_name"`
 	Discriminator string    `json:"discriminator"`
 	Avatar        string    `json:"avatar"`
+	Banner        string    `json:"banner"`
+	Member        bool      `json:"-"`
 	LoggedInUntil time.Time `json:"-"`
 }
+
+// DisplayName is the name Discord shows for the user
+func (d *DiscordMeBody) DisplayName() string {
+	if d.GlobalName != "" {
+		return d.GlobalName
+	}
+	return d.Username
+}
+
+// Tag is the unique handle, users who have moved to the new username system
+// have the discriminator "0"
+func (d *DiscordMeBody) Tag() string {
+	if d.Discriminator == "" || d.Discriminator == "0" {
+		return d.Username
+	}
+	return d.Username + "#" + d.Discriminator
+}
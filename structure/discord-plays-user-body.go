@@ -1,8 +1,12 @@
 package structure
 
 type DiscordPlaysUserBody struct {
-	Id       string `json:"id"`
-	Username string `json:"username"`
-	Avatar   string `json:"avatar"`
-	Admin    bool   `json:"admin"`
+	Id          string `json:"id"`
+	LegacyId    string `json:"legacy_id,omitempty"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Avatar      string `json:"avatar"`
+	Banner      string `json:"banner,omitempty"`
+	Admin       bool   `json:"admin"`
+	Member      bool   `json:"member"`
 }
@@ -0,0 +1,14 @@
+package structure
+
+type IdentityClaims struct {
+	Issuer        string `json:"iss"`
+	Subject       string `json:"sub"`
+	LegacySubject string `json:"legacy_sub,omitempty"`
+	Audience      string `json:"aud,omitempty"`
+	IssuedAt      int64  `json:"iat"`
+	ExpiresAt     int64  `json:"exp"`
+	Username      string `json:"username"`
+	DisplayName   string `json:"display_name"`
+	Admin         bool   `json:"admin"`
+	Member        bool   `json:"member"`
+}
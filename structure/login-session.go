@@ -0,0 +1,17 @@
+package structure
+
+import "time"
+
+// LoginSession is the server side half of a login, the cookie only holds the
+// Id so the refresh token never leaves the server. Provider is empty for the
+// main identity provider or the linked account provider used to log in.
+type LoginSession struct {
+	Id           string `gorm:"primaryKey"`
+	UserId       string `gorm:"index"`
+	Provider     string
+	RefreshToken []byte
+	RememberMe   bool
+	CreatedAt    time.Time
+	RenewedAt    time.Time
+	ExpiresAt    time.Time
+}
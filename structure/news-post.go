@@ -0,0 +1,22 @@
+package structure
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+type NewsPost struct {
+	gorm.Model
+	Slug       string `gorm:"uniqueIndex"`
+	Title      string
+	Summary    string
+	Body       string
+	AuthorName string
+	Draft      bool
+	PublishAt  time.Time      `gorm:"index"`
+	Projects   []*ProjectItem `gorm:"many2many:news_post_projects;"`
+}
+
+func (n *NewsPost) IsPublished(now time.Time) bool {
+	return !n.Draft && !n.PublishAt.After(now)
+}
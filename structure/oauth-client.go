@@ -0,0 +1,52 @@
+package structure
+
+import (
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+type OAuthClient struct {
+	gorm.Model
+	ClientId     string `gorm:"uniqueIndex"`
+	SecretHash   string
+	Name         string
+	RedirectUris string
+	Public       bool
+	Disabled     bool
+}
+
+func (c *OAuthClient) RedirectUriList() []string {
+	return strings.Fields(c.RedirectUris)
+}
+
+func (c *OAuthClient) HasRedirectUri(a string) bool {
+	for _, i := range c.RedirectUriList() {
+		if i == a {
+			return true
+		}
+	}
+	return false
+}
+
+type OAuthConsent struct {
+	OAuthClientID uint   `gorm:"primaryKey;autoIncrement:false"`
+	UserId        string `gorm:"primaryKey"`
+	Scope         string
+	CreatedAt     time.Time
+}
+
+type OAuthAuthCode struct {
+	CodeHash      string `gorm:"primaryKey"`
+	OAuthClientID uint
+	RedirectUri   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	Subject       string
+	Username      string
+	Name          string
+	Avatar        string
+	Admin         bool
+	ExpiresAt     time.Time `gorm:"index"`
+}
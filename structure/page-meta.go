@@ -0,0 +1,14 @@
+package structure
+
+type PageMeta struct {
+	Title       string
+	Description string
+	Canonical   string
+	Image       string
+	ImageWidth  int
+	ImageHeight int
+	Type        string
+	LargeImage  bool
+	NoIndex     bool
+	JsonLd      interface{}
+}
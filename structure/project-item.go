@@ -12,6 +12,10 @@ type ProjectItem struct {
 	ImageAlt    *string
 	Notion      *string
 	Github      *string
+	// Aliases are other subdomains for the project, separated by commas
+	Aliases *string
+	// Domains are custom domains for the project, separated by commas
+	Domains *string
 }
 
 func NewProjectItem(code, name, subText, description, invite, imageAlt, notion, github string) *ProjectItem {
@@ -0,0 +1,11 @@
+package structure
+
+import "time"
+
+// ServerSecret holds keys the server generates for itself when they are not
+// set in the environment
+type ServerSecret struct {
+	Name      string `gorm:"primaryKey"`
+	Value     []byte
+	CreatedAt time.Time
+}
@@ -0,0 +1,12 @@
+package structure
+
+import "time"
+
+// SigningKey is an identity token signing key, PrivateKey is the PKCS #8 key
+// sealed with a key derived from the session keys
+type SigningKey struct {
+	Kid        string `gorm:"primaryKey"`
+	PrivateKey []byte
+	CreatedAt  time.Time
+	RetiredAt  *time.Time
+}
@@ -0,0 +1,11 @@
+package structure
+
+import "time"
+
+const SiteTextAbout = "about"
+
+type SiteText struct {
+	Key       string `gorm:"primaryKey"`
+	Value     string
+	UpdatedAt time.Time
+}
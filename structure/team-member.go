@@ -0,0 +1,21 @@
+package structure
+
+import "gorm.io/gorm"
+
+type TeamMember struct {
+	gorm.Model
+	Name     string
+	Role     string
+	Bio      string
+	Avatar   string
+	Position int
+	Links    []*TeamMemberLink
+}
+
+type TeamMemberLink struct {
+	ID           uint `gorm:"primarykey"`
+	TeamMemberID uint `gorm:"index"`
+	Label        string
+	Url          string
+	Position     int
+}
@@ -0,0 +1,49 @@
+package structure
+
+import "time"
+
+// User is the last known profile of everyone who has logged in, it lets other
+// identities such as GitHub be linked to the Discord account. The privacy
+// settings hide the profile page, hide the linked accounts and stop bots from
+// finding the user by their Discord ID.
+type User struct {
+	ID                 uint   `gorm:"primaryKey"`
+	DiscordId          string `gorm:"uniqueIndex"`
+	PublicId           string `gorm:"uniqueIndex"`
+	Username           string
+	GlobalName         string
+	Discriminator      string
+	Avatar             string
+	Banner             string
+	PublicProfile      bool `gorm:"default:true"`
+	ShowLinkedAccounts bool `gorm:"default:true"`
+	BotLookup          bool `gorm:"default:true"`
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	LinkedIdentities   []LinkedIdentity
+}
+
+// MeBody rebuilds the Discord profile for logins which did not go through
+// Discord
+func (u *User) MeBody() *DiscordMeBody {
+	return &DiscordMeBody{
+		Id:            u.DiscordId,
+		Username:      u.Username,
+		GlobalName:    u.GlobalName,
+		Discriminator: u.Discriminator,
+		Avatar:        u.Avatar,
+		Banner:        u.Banner,
+	}
+}
+
+// LinkedIdentity is an account on another site which belongs to the user,
+// each one can only ever be linked to a single user
+type LinkedIdentity struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"index"`
+	Provider   string `gorm:"uniqueIndex:idx_linked_identity_subject"`
+	Subject    string `gorm:"uniqueIndex:idx_linked_identity_subject"`
+	Username   string
+	ProfileUrl string
+	CreatedAt  time.Time
+}